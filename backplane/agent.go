@@ -79,17 +79,20 @@ type ShutdownReply struct {
 
 // InfoReply is the reply from the info action
 type InfoReply struct {
-	BackplaneVersion string      `json:"backplane_version"`
-	Version          string      `json:"version"`
-	Paused           bool        `json:"paused"`
-	Facts            interface{} `json:"facts"`
-	Healthy          bool        `json:"healthy"`
-	LogLevel         string      `json:"loglevel"`
-	HealthFeature    bool        `json:"healthcheck_feature"`
-	PauseFeature     bool        `json:"pause_feature"`
-	ShutdownFeature  bool        `json:"shutdown_feature"`
-	FactsFeature     bool        `json:"facts_feature"`
-	LogLevelFeature  bool        `json:"loglevel_feature"`
+	BackplaneVersion string              `json:"backplane_version"`
+	Version          string              `json:"version"`
+	Paused           bool                `json:"paused"`
+	Facts            interface{}         `json:"facts"`
+	Healthy          bool                `json:"healthy"`
+	LogLevel         string              `json:"loglevel"`
+	HealthFeature    bool                `json:"healthcheck_feature"`
+	PauseFeature     bool                `json:"pause_feature"`
+	ShutdownFeature  bool                `json:"shutdown_feature"`
+	FactsFeature     bool                `json:"facts_feature"`
+	LogLevelFeature  bool                `json:"loglevel_feature"`
+	Outbox           *OutboxStats        `json:"outbox,omitempty"`
+	Publisher        *PublisherStats     `json:"publisher,omitempty"`
+	Subscriptions    []SubscriptionStats `json:"subscriptions,omitempty"`
 }
 
 // PausableReply is the reply format expected from Pausable actions
@@ -134,22 +137,41 @@ func (m *Management) startAgents(ctx context.Context) (err error) {
 	}
 
 	agent.MustRegisterAction("info", m.roAction(m.infoAction))
+	agent.MustRegisterAction("factschema", m.roAction(m.factSchemaAction))
+	agent.MustRegisterAction("facthistory", m.roAction(m.factHistoryAction))
+	agent.MustRegisterAction("publisher", m.roAction(m.publisherAction))
 	agent.MustRegisterAction("ping", m.roAction(m.pingAction))
 
-	return m.cserver.RegisterAgent(ctx, md.Name, agent)
+	m.mu.Lock()
+	srv := m.cserver
+	m.mu.Unlock()
+
+	return srv.RegisterAgent(ctx, md.Name, agent)
+}
+
+// authorization is a snapshot of the authorization rules, m.mu is not held while actions
+// run so that slow actions do not block reconfiguration and publishing, actions are instead
+// serialized using actionMu
+func (m *Management) authorization() Authorization {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cfg.auth
 }
 
 func (m *Management) roAction(a mcorpc.Action) mcorpc.Action {
 	return func(ctx context.Context, req *mcorpc.Request, reply *mcorpc.Reply, agent *mcorpc.Agent, conn inter.ConnectorInfo) {
-		if !m.cfg.auth.ROAllowed(req.CallerID) {
+		auth := m.authorization()
+
+		if !auth.ROAllowed(req.CallerID) {
 			reply.Statuscode = mcorpc.Aborted
 			reply.Statusmsg = "You are not authorized to call this agent or action."
 
 			return
 		}
 
-		m.mu.Lock()
-		defer m.mu.Unlock()
+		m.actionMu.Lock()
+		defer m.actionMu.Unlock()
 
 		a(ctx, req, reply, agent, conn)
 	}
@@ -157,15 +179,17 @@ func (m *Management) roAction(a mcorpc.Action) mcorpc.Action {
 
 func (m *Management) fullAction(a mcorpc.Action) mcorpc.Action {
 	return func(ctx context.Context, req *mcorpc.Request, reply *mcorpc.Reply, agent *mcorpc.Agent, conn inter.ConnectorInfo) {
-		if !m.cfg.auth.FullAllowed(req.CallerID) {
+		auth := m.authorization()
+
+		if !auth.FullAllowed(req.CallerID) {
 			reply.Statuscode = mcorpc.Aborted
 			reply.Statusmsg = "You are not authorized to call this agent or action."
 
 			return
 		}
 
-		m.mu.Lock()
-		defer m.mu.Unlock()
+		m.actionMu.Lock()
+		defer m.actionMu.Unlock()
 
 		a(ctx, req, reply, agent, conn)
 	}
@@ -260,14 +284,24 @@ func (m *Management) infoAction(ctx context.Context, req *mcorpc.Request, reply
 		BackplaneVersion: agent.Metadata().Version,
 		Version:          "unknown",
 		LogLevel:         "unknown",
+		Facts:            m.Facts(),
 	}
 
 	if m.cfg.infosource != nil {
 		info.Version = m.cfg.infosource.Version()
-		info.Facts = m.cfg.infosource.FactData()
 		info.FactsFeature = true
 	}
 
+	if m.cfg.publishdata {
+		stats := m.OutboxStats()
+		info.Outbox = &stats
+
+		totals := m.PublisherStats()
+		info.Publisher = &totals
+	}
+
+	info.Subscriptions = m.Subscriptions()
+
 	if m.cfg.healthcheckable != nil {
 		_, info.Healthy = m.cfg.healthcheckable.HealthCheck()
 		info.HealthFeature = true
@@ -409,6 +443,24 @@ func AgentDDL() *agent.DDL {
 				DisplayAs:   "Log Level Feature",
 				Type:        "boolean",
 			},
+
+			"outbox": {
+				Description: "Counters for the data outbox when the data publisher is enabled",
+				DisplayAs:   "Data Outbox",
+				Type:        "hash",
+			},
+
+			"publisher": {
+				Description: "Totals for the data published when the data publisher is enabled",
+				DisplayAs:   "Data Publisher",
+				Type:        "hash",
+			},
+
+			"subscriptions": {
+				Description: "Counters for messages received, rejected and dropped by each subscription",
+				DisplayAs:   "Subscriptions",
+				Type:        "array",
+			},
 		},
 		Aggregation: []agent.ActionAggregateItem{
 			{
@@ -428,6 +480,75 @@ func AgentDDL() *agent.DDL {
 
 	ddl.Actions = append(ddl.Actions, act)
 
+	act = &agent.Action{
+		Name:        "factschema",
+		Description: "Describes the facts of the managed service",
+		Display:     "always",
+		Input:       make(map[string]*common.InputItem),
+		Output: map[string]*common.OutputItem{
+			"facts": {
+				Description: "The name, type and description of every described fact",
+				DisplayAs:   "Facts",
+				Type:        "array",
+			},
+		},
+	}
+
+	ddl.Actions = append(ddl.Actions, act)
+
+	act = &agent.Action{
+		Name:        "facthistory",
+		Description: "Recent changes to the facts of the managed service",
+		Display:     "always",
+		Input:       make(map[string]*common.InputItem),
+		Output: map[string]*common.OutputItem{
+			"changes": {
+				Description: "The added, removed and changed facts for each change, oldest first",
+				DisplayAs:   "Changes",
+				Type:        "array",
+			},
+		},
+	}
+
+	ddl.Actions = append(ddl.Actions, act)
+
+	act = &agent.Action{
+		Name:        "publisher",
+		Description: "Statistics about data published by the managed service",
+		Display:     "always",
+		Input:       make(map[string]*common.InputItem),
+		Output: map[string]*common.OutputItem{
+			"enabled": {
+				Description: "If the data publisher is enabled",
+				DisplayAs:   "Enabled",
+				Type:        "boolean",
+			},
+			"totals": {
+				Description: "Published messages, bytes, errors and queued messages for all destinations",
+				DisplayAs:   "Totals",
+				Type:        "hash",
+			},
+			"destinations": {
+				Description: "Published messages, bytes, errors and queued messages for each destination",
+				DisplayAs:   "Destinations",
+				Type:        "array",
+			},
+			"outbox": {
+				Description: "Counters for the data outbox",
+				DisplayAs:   "Data Outbox",
+				Type:        "hash",
+			},
+		},
+		Aggregation: []agent.ActionAggregateItem{
+			{
+				Function:  "summary",
+				Arguments: json.RawMessage(`["enabled"]`),
+			},
+		},
+	}
+
+	ddl.Actions = append(ddl.Actions, act)
+
 	act = &agent.Action{
 		Name:        "shutdown",
 		Description: "Terminates the managed service",
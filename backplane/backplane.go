@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/choria-io/go-choria/providers/agent/mcorpc"
 	"github.com/choria-io/go-choria/server"
@@ -29,20 +30,70 @@ var Version = "development"
 
 // Management is a embeddable Choria based backplane for your Go application
 type Management struct {
-	cfg     *Config
-	cserver *server.Instance
-	mu      *sync.Mutex
-	factsMu *sync.Mutex
-	log     *logrus.Entry
-	agent   *mcorpc.Agent
-	outbox  chan *DataItem
+	// eventSeq is first to keep it 64 bit aligned for atomic access
+	eventSeq uint64
+
+	cfg      *Config
+	service  string
+	identity string
+	cserver  *server.Instance
+	mu       *sync.Mutex
+	reconfMu *sync.Mutex
+	actionMu *sync.Mutex
+	log      *logrus.Entry
+	agent    *mcorpc.Agent
+	outbox   chan *DataItem
+	queue    *dataQueue
+	batcher  *dataBatcher
+	metrics  *publisherMetrics
+	subsMu   *sync.Mutex
+	subs     map[*Subscription]struct{}
+
+	factsMu   *sync.Mutex
+	factsJSON []byte
+	factsFile string
+	factsReq  chan struct{}
+	warned    map[string]bool
+	stdFacts  map[string]interface{}
+	history   []FactChange
+
+	ctx        context.Context
+	wg         *sync.WaitGroup
+	serverWg   *sync.WaitGroup
+	stopServer func()
+
+	stateMu    *sync.Mutex
+	state      ConnectionState
+	stateSince time.Time
+
+	// connected is 1 while state is Connected, it can be read without taking any locks
+	connected uint32
+
+	stopConnect  func()
+	connAttempts int
+	lastAttempt  time.Time
+	nextAttempt  time.Time
+	lastConnErr  error
 }
 
 // Run creates a new instance of the backplane
 func Run(ctx context.Context, wg *sync.WaitGroup, conf ConfigProvider, opts ...Option) (m *Management, err error) {
 	m = &Management{
-		mu:     &sync.Mutex{},
-		outbox: make(chan *DataItem, 1),
+		mu:         &sync.Mutex{},
+		reconfMu:   &sync.Mutex{},
+		actionMu:   &sync.Mutex{},
+		factsMu:    &sync.Mutex{},
+		factsReq:   make(chan struct{}, 1),
+		warned:     make(map[string]bool),
+		stateMu:    &sync.Mutex{},
+		state:      Connecting,
+		stateSince: time.Now(),
+		outbox:     make(chan *DataItem, 1),
+		metrics:    newPublisherMetrics(),
+		subsMu:     &sync.Mutex{},
+		subs:       make(map[*Subscription]struct{}),
+		ctx:        ctx,
+		wg:         wg,
 	}
 
 	m.cfg, err = newConfig("backplane", conf, opts...)
@@ -50,33 +101,135 @@ func Run(ctx context.Context, wg *sync.WaitGroup, conf ConfigProvider, opts ...O
 		return nil, fmt.Errorf("could not initialize Choria backplane: %s", err)
 	}
 
+	err = m.cfg.setupFramework(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize Choria backplane: %s", err)
+	}
+
 	m.log = m.cfg.fw.Logger("backplane")
+	m.service = conf.Name()
+	m.identity = m.cfg.ccfg.Identity
+	m.stdFacts = standardFacts(m.stateSince)
+
+	if m.cfg.healthThreshold > 0 {
+		m.cfg.healthcheckable = newConnectionHealthCheck(m, m.cfg.healthcheckable, m.cfg.healthThreshold)
+	}
 
-	if m.cfg.infosource != nil {
-		f, err := m.exposeFacts(ctx, wg)
+	if m.cfg.publishdata {
+		m.queue, err = newDataQueue(m.cfg, m.isConnected, m.log.Warnf)
 		if err != nil {
-			return nil, fmt.Errorf("could not expose facts: %s", err)
+			return nil, fmt.Errorf("could not create data outbox: %s", err)
+		}
+
+		if m.cfg.batchDelay > 0 {
+			m.batcher = newDataBatcher(m.cfg, m.queue.push, m.metrics.failed, m.log.Warnf)
 		}
+	}
 
-		m.cfg.ccfg.FactSourceFile = f
+	f, err := m.exposeFacts(ctx, wg)
+	if err != nil {
+		return nil, fmt.Errorf("could not expose facts: %s", err)
 	}
 
-	err = m.startServer(ctx, wg)
+	m.cfg.ccfg.FactSourceFile = f
+
+	if m.cfg.backgroundConnect {
+		m.startBackgroundConnect()
+	} else {
+		err = m.connect(ctx, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wg.Add(1)
+	go m.instanceWaiter(ctx, wg)
+
+	return m, nil
+}
+
+// startInstance starts the Choria server, agents and data publisher using a context
+// and wait group private to this instance so that it can later be restarted, when
+// timeout is not 0 the initial connection to the broker will be abandoned after timeout
+func (m *Management) startInstance(parent context.Context, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithCancel(parent)
+	wg := &sync.WaitGroup{}
+
+	m.mu.Lock()
+	m.serverWg = wg
+	m.stopServer = cancel
+	m.cfg.ccfg.Choria.MiddlewareHosts = m.cfg.resolveBrokers()
+	m.mu.Unlock()
+
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, cancel)
+		err = m.startServer(ctx, wg)
+		if !timer.Stop() && err == nil {
+			err = fmt.Errorf("initial connection timed out after %s", timeout)
+		}
+	} else {
+		err = m.startServer(ctx, wg)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("could not start Choria server: %s", err)
+		m.stopInstance()
+		return fmt.Errorf("could not start Choria server: %s", err)
 	}
 
 	err = m.startAgents(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not start backplane agents: %s", err)
+		m.stopInstance()
+		return fmt.Errorf("could not start backplane agents: %s", err)
 	}
 
+	m.watchConnection()
+	m.startSubscriptions()
+
 	if m.cfg.publishdata {
 		err = m.startDataPublisher(ctx, wg)
 		if err != nil {
-			return nil, fmt.Errorf("could not start data publisher: %s", err)
+			m.stopInstance()
+			return fmt.Errorf("could not start data publisher: %s", err)
 		}
 	}
 
-	return m, nil
+	return nil
+}
+
+// stopInstance stops the running Choria server and waits for it to finish
+func (m *Management) stopInstance() {
+	m.mu.Lock()
+	cancel := m.stopServer
+	wg := m.serverWg
+	m.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	wg.Wait()
+
+	m.mu.Lock()
+	m.cserver = nil
+	m.stopServer = nil
+	m.mu.Unlock()
+}
+
+// instanceWaiter ties the life cycle of the current server instance to the wait group passed to Run
+func (m *Management) instanceWaiter(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	<-ctx.Done()
+
+	m.stopConnectLoop()
+	m.stopInstance()
+	m.setState(Closed)
+
+	if m.batcher != nil {
+		m.batcher.flushAll()
+	}
+
+	if m.queue != nil {
+		m.queue.close()
+	}
 }
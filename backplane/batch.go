@@ -0,0 +1,333 @@
+package backplane
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DataBatchProtocol identifies a DataBatch envelope
+const DataBatchProtocol = "choria:backplane:data_batch:1"
+
+// DataCompression is the algorithm used to compress batches of data
+type DataCompression int
+
+const (
+	// NoCompression publishes batches uncompressed
+	NoCompression DataCompression = iota
+
+	// GzipCompression compresses batches using gzip
+	GzipCompression
+
+	// ZstdCompression compresses batches using zstd
+	ZstdCompression
+)
+
+// String returns the name of the compression algorithm as used in the DataBatch envelope
+func (c DataCompression) String() string {
+	switch c {
+	case GzipCompression:
+		return "gzip"
+	case ZstdCompression:
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+// DataBatch is the envelope published when data batching is enabled, use UnpackData to
+// retrieve the individual items
+type DataBatch struct {
+	// Protocol is always DataBatchProtocol
+	Protocol string `json:"protocol"`
+
+	// Compression is the algorithm Data is compressed with, empty when not compressed
+	Compression string `json:"compression,omitempty"`
+
+	// Count is how many items are in the batch
+	Count int `json:"count"`
+
+	// Data holds the items each prefixed by its length as a 4 byte big endian integer,
+	// compressed using Compression
+	Data []byte `json:"data"`
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec creates a zstd encoder and decoder once, EncodeAll and DecodeAll can be used concurrently
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr == nil {
+			zstdDecoder, zstdErr = zstd.NewReader(nil)
+		}
+	})
+
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// BatchData combines published items with the same destination into a single message that
+// is published once it holds maxItems items, maxBytes bytes of data or when the oldest item
+// is maxDelay old, 0 disables the item and byte limits
+func BatchData(maxItems int, maxBytes int, maxDelay time.Duration) Option {
+	return func(c *Config) {
+		c.batchItems = maxItems
+		c.batchBytes = maxBytes
+		c.batchDelay = maxDelay
+	}
+}
+
+// CompressData compresses batches created by BatchData
+func CompressData(compression DataCompression) Option {
+	return func(c *Config) {
+		c.compression = compression
+	}
+}
+
+// UnpackData extracts the items from data published by the backplane, data that is not
+// a DataBatch is returned as the only item
+func UnpackData(data []byte) ([][]byte, error) {
+	batch := &DataBatch{}
+	err := json.Unmarshal(data, batch)
+	if err != nil || batch.Protocol != DataBatchProtocol {
+		return [][]byte{data}, nil
+	}
+
+	body := batch.Data
+
+	switch batch.Compression {
+	case "":
+	case GzipCompression.String():
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress batch: %s", err)
+		}
+
+		body, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress batch: %s", err)
+		}
+
+	case ZstdCompression.String():
+		_, dec, err := zstdCodec()
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress batch: %s", err)
+		}
+
+		body, err = dec.DecodeAll(body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress batch: %s", err)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported batch compression %q", batch.Compression)
+	}
+
+	items := [][]byte{}
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("could not decode batch: truncated item length")
+		}
+
+		size := binary.BigEndian.Uint32(body)
+		body = body[4:]
+
+		if uint64(size) > uint64(len(body)) {
+			return nil, fmt.Errorf("could not decode batch: item of %d bytes exceeds the remaining %d bytes", size, len(body))
+		}
+
+		items = append(items, body[:size])
+		body = body[size:]
+	}
+
+	if len(items) != batch.Count {
+		return nil, fmt.Errorf("batch holds %d items but %d were expected", len(items), batch.Count)
+	}
+
+	return items, nil
+}
+
+// batchKey groups items that are published to the same place
+type batchKey struct {
+	destination string
+	target      string
+}
+
+type pendingBatch struct {
+	items [][]byte
+	size  int
+	timer *time.Timer
+}
+
+// dataBatcher collects items into batches and passes completed batches to output, batches
+// that could not be encoded or passed to output are reported to failed
+type dataBatcher struct {
+	maxItems    int
+	maxBytes    int
+	maxDelay    time.Duration
+	compression DataCompression
+	output      func(*DataItem) error
+	failed      func(*DataItem, error)
+	log         func(format string, args ...interface{})
+
+	mu      *sync.Mutex
+	pending map[batchKey]*pendingBatch
+}
+
+func newDataBatcher(c *Config, output func(*DataItem) error, failed func(*DataItem, error), log func(format string, args ...interface{})) *dataBatcher {
+	return &dataBatcher{
+		maxItems:    c.batchItems,
+		maxBytes:    c.batchBytes,
+		maxDelay:    c.batchDelay,
+		compression: c.compression,
+		output:      output,
+		failed:      failed,
+		log:         log,
+		mu:          &sync.Mutex{},
+		pending:     make(map[batchKey]*pendingBatch),
+	}
+}
+
+func (b *dataBatcher) add(item *DataItem) error {
+	key := batchKey{destination: item.Destination, target: item.TargetAgent}
+
+	b.mu.Lock()
+
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &pendingBatch{}
+		batch.timer = time.AfterFunc(b.maxDelay, func() {
+			b.mu.Lock()
+			if b.pending[key] != batch {
+				b.mu.Unlock()
+				return
+			}
+			b.take(key)
+			b.mu.Unlock()
+
+			err := b.flush(key, batch)
+			if err != nil {
+				b.log("Could not publish batch of data: %s", err)
+			}
+		})
+		b.pending[key] = batch
+	}
+
+	batch.items = append(batch.items, item.Data)
+	batch.size += len(item.Data)
+
+	if (b.maxItems > 0 && len(batch.items) >= b.maxItems) || (b.maxBytes > 0 && batch.size >= b.maxBytes) {
+		b.take(key)
+		b.mu.Unlock()
+
+		return b.flush(key, batch)
+	}
+
+	b.mu.Unlock()
+
+	return nil
+}
+
+// flushAll publishes all pending batches
+func (b *dataBatcher) flushAll() {
+	b.mu.Lock()
+	pending := make(map[batchKey]*pendingBatch, len(b.pending))
+	for key := range b.pending {
+		pending[key] = b.take(key)
+	}
+	b.mu.Unlock()
+
+	for key, batch := range pending {
+		err := b.flush(key, batch)
+		if err != nil {
+			b.log("Could not publish batch of data: %s", err)
+		}
+	}
+}
+
+// take removes the batch for key from the pending batches, must be called with mu held
+func (b *dataBatcher) take(key batchKey) *pendingBatch {
+	batch := b.pending[key]
+	batch.timer.Stop()
+	delete(b.pending, key)
+
+	return batch
+}
+
+// flush publishes a batch that was removed from the pending batches, must be called without
+// mu held as output can block, failures are recorded using failed
+func (b *dataBatcher) flush(key batchKey, batch *pendingBatch) error {
+	item := &DataItem{
+		Destination: key.destination,
+		TargetAgent: key.target,
+	}
+
+	var err error
+	item.Data, err = b.encode(batch.items)
+	if err == nil {
+		err = b.output(item)
+	}
+
+	if err != nil {
+		b.failed(item, err)
+	}
+
+	return err
+}
+
+func (b *dataBatcher) encode(items [][]byte) ([]byte, error) {
+	size := 0
+	for _, item := range items {
+		size += 4 + len(item)
+	}
+
+	// items are stored as they are rather than base64 encoded, the envelope encodes the body once
+	body := make([]byte, 0, size)
+	for _, item := range items {
+		body = append(body, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(body[len(body)-4:], uint32(len(item)))
+		body = append(body, item...)
+	}
+
+	switch b.compression {
+	case GzipCompression:
+		buf := &bytes.Buffer{}
+		w := gzip.NewWriter(buf)
+		_, err := w.Write(body)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not compress batch: %s", err)
+		}
+
+		body = buf.Bytes()
+
+	case ZstdCompression:
+		enc, _, err := zstdCodec()
+		if err != nil {
+			return nil, fmt.Errorf("could not compress batch: %s", err)
+		}
+
+		body = enc.EncodeAll(body, nil)
+	}
+
+	return json.Marshal(DataBatch{
+		Protocol:    DataBatchProtocol,
+		Compression: b.compression.String(),
+		Count:       len(items),
+		Data:        body,
+	})
+}
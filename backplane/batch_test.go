@@ -0,0 +1,163 @@
+package backplane
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testBatcher(items int, delay time.Duration, output func(*DataItem) error, failed func(*DataItem, error)) *dataBatcher {
+	c := &Config{batchItems: items, batchDelay: delay, compression: GzipCompression}
+
+	return newDataBatcher(c, output, failed, func(string, ...interface{}) {})
+}
+
+func TestDataBatcherFlushesFullBatches(t *testing.T) {
+	var published []*DataItem
+
+	b := testBatcher(2, time.Hour, func(item *DataItem) error {
+		published = append(published, item)
+		return nil
+	}, func(item *DataItem, err error) {
+		t.Fatalf("unexpected failure: %s", err)
+	})
+
+	for _, d := range []string{"one", "two", "three"} {
+		err := b.add(&DataItem{Data: []byte(d), TargetAgent: "test"})
+		if err != nil {
+			t.Fatalf("could not add item: %s", err)
+		}
+	}
+
+	if len(published) != 1 {
+		t.Fatalf("expected 1 published batch got %d", len(published))
+	}
+
+	items, err := UnpackData(published[0].Data)
+	if err != nil {
+		t.Fatalf("could not unpack batch: %s", err)
+	}
+
+	if len(items) != 2 || string(items[0]) != "one" || string(items[1]) != "two" {
+		t.Fatalf("unexpected batch contents %q", items)
+	}
+
+	if published[0].TargetAgent != "test" {
+		t.Fatalf("expected batch for agent test got %q", published[0].TargetAgent)
+	}
+
+	b.flushAll()
+
+	if len(published) != 2 {
+		t.Fatalf("expected flushAll to publish the pending batch, got %d batches", len(published))
+	}
+}
+
+func TestDataBatcherOutputWithoutLock(t *testing.T) {
+	var b *dataBatcher
+
+	done := make(chan error, 1)
+
+	b = testBatcher(1, time.Hour, func(item *DataItem) error {
+		// adding from within output would deadlock if the batcher held its lock
+		if item.TargetAgent == "first" {
+			return b.add(&DataItem{Data: []byte("second"), TargetAgent: "second"})
+		}
+
+		return nil
+	}, func(item *DataItem, err error) {})
+
+	go func() { done <- b.add(&DataItem{Data: []byte("first"), TargetAgent: "first"}) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("could not add item: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("add did not complete, output was called while holding the batcher lock")
+	}
+}
+
+func TestDataBatcherTimerFailuresAreRecorded(t *testing.T) {
+	mu := &sync.Mutex{}
+	failures := []*DataItem{}
+	recorded := make(chan struct{}, 1)
+
+	b := testBatcher(0, 10*time.Millisecond, func(item *DataItem) error {
+		return ErrOutboxFull
+	}, func(item *DataItem, err error) {
+		if !errors.Is(err, ErrOutboxFull) {
+			t.Errorf("expected ErrOutboxFull got %s", err)
+		}
+
+		mu.Lock()
+		failures = append(failures, item)
+		mu.Unlock()
+
+		recorded <- struct{}{}
+	})
+
+	err := b.add(&DataItem{Data: []byte("one"), Destination: "test.data"})
+	if err != nil {
+		t.Fatalf("could not add item: %s", err)
+	}
+
+	select {
+	case <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("failed timer flush was not recorded")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(failures) != 1 || failures[0].Destination != "test.data" {
+		t.Fatalf("expected one failure for test.data got %v", failures)
+	}
+}
+
+func TestUnpackDataRoundTrip(t *testing.T) {
+	items := [][]byte{[]byte("one"), {}, {0, 1, 2, 255}, []byte(`{"json":true}`)}
+
+	for _, compression := range []DataCompression{NoCompression, GzipCompression, ZstdCompression} {
+		b := &dataBatcher{compression: compression}
+
+		data, err := b.encode(items)
+		if err != nil {
+			t.Fatalf("%s: could not pack batch: %s", compression, err)
+		}
+
+		unpacked, err := UnpackData(data)
+		if err != nil {
+			t.Fatalf("%s: could not unpack batch: %s", compression, err)
+		}
+
+		if len(unpacked) != len(items) {
+			t.Fatalf("%s: expected %d items got %d", compression, len(items), len(unpacked))
+		}
+
+		for i := range items {
+			if string(unpacked[i]) != string(items[i]) {
+				t.Fatalf("%s: item %d: expected %q got %q", compression, i, items[i], unpacked[i])
+			}
+		}
+	}
+}
+
+func TestUnpackDataTruncated(t *testing.T) {
+	// a 5 byte item with only 4 bytes following the length
+	body := []byte{0, 0, 0, 5, 'h', 'e', 'l', 'l'}
+
+	data, err := json.Marshal(DataBatch{Protocol: DataBatchProtocol, Compression: NoCompression.String(), Count: 1, Data: body})
+	if err != nil {
+		t.Fatalf("could not marshal batch: %s", err)
+	}
+
+	_, err = UnpackData(data)
+	if err == nil {
+		t.Fatalf("expected a truncated batch to fail")
+	}
+}
@@ -2,13 +2,14 @@ package backplane
 
 import (
 	"fmt"
-	"regexp"
+	"net"
 	"time"
 
 	"github.com/choria-io/go-choria/protocol"
 
 	"github.com/choria-io/go-choria/choria"
 	chconf "github.com/choria-io/go-choria/config"
+	"github.com/sirupsen/logrus"
 )
 
 // Config configures the backplane
@@ -16,23 +17,54 @@ type Config struct {
 	name         string
 	auth         Authorization
 	brokers      []string
+	srvDomain    string
+	srvResolver  SRVResolver
 	appname      string
 	logfile      string
 	loglevel     string
 	tls          *TLSConf
+	tlsState     string
+	nats         *NATSConf
 	provider     ConfigProvider
 	opts         []Option
 	fw           *choria.Framework
 	ccfg         *chconf.Config
 	factInterval time.Duration
+	factMirror   string
+	factDebounce time.Duration
+	flatten      *FactFlattening
+	factSchema   []FactDescription
+	rejectFacts  bool
+	factHistory  int
+	factHandlers []FactChangeHandler
+	factsDest    string
+	publishFacts bool
 	maxStopDelay time.Duration
 
+	backgroundConnect bool
+	connectTimeout    time.Duration
+	connectMinBackoff time.Duration
+	connectMaxBackoff time.Duration
+
 	publishdata     bool
+	outboxSize      int
+	outboxPolicy    OverflowPolicy
+	outboxTimeout   time.Duration
+	outboxDir       string
+	spoolMaxBytes   int64
+	spoolMaxAge     time.Duration
+	batchItems      int
+	batchBytes      int
+	batchDelay      time.Duration
+	compression     DataCompression
+	eventsDest      string
 	pausable        Pausable
 	infosource      InfoSource
 	healthcheckable HealthCheckable
+	healthThreshold time.Duration
 	stopable        Stopable
 	logsetable      LogLevelSetable
+	stateHandlers   []StateChangeHandler
 }
 
 // TLSConf describes the TLS config for a NATS connection
@@ -59,6 +91,28 @@ type TLSConf struct {
 	Cache string `json:"cache" yaml:"cache"`
 }
 
+// NATSConf describes authentication against NATS servers, TLS can be combined with these.
+//
+// NKey seeds on their own are not supported as the embedded Choria connector does not accept
+// a NKey signing callback, a credentials file holding the seed can be used instead
+type NATSConf struct {
+	// User is the user to connect as
+	User string `json:"user" yaml:"user"`
+
+	// Password is the password for User
+	Password string `json:"password" yaml:"password"`
+
+	// Credentials sets the path to a NATS 2.0 decentralized authentication credentials file
+	// holding a JWT and NKey seed as produced by nsc
+	Credentials string `json:"credentials" yaml:"credentials"`
+}
+
+// NATSConfigProvider can optionally be implemented by a ConfigProvider to supply NATS authentication
+type NATSConfigProvider interface {
+	// NATS is the NATS authentication configuration, nil meaning no NATS authentication
+	NATS() *NATSConf
+}
+
 // ConfigProvider provides management backplane configuration
 type ConfigProvider interface {
 	// MiddlewareHosts are hosts in host:port format to connect to
@@ -71,7 +125,7 @@ type ConfigProvider interface {
 	// LogFile is the file to use for logging the backplane related logs, "" means stdout
 	LogFile() string
 
-	// LogLevel is the logging level, one of debug, info, warn, error
+	// LogLevel is the logging level, one of debug, info, warn, error or fatal
 	LogLevel() string
 
 	// TLS is a TLS configuration, nil meaning disable security
@@ -84,41 +138,103 @@ type ConfigProvider interface {
 // Option is a func that can configure the backplane
 type Option func(*Config)
 
+// newConfig validates the configuration and prepares the Choria configuration, the Choria
+// framework is created separately using setupFramework
 func newConfig(name string, cfg ConfigProvider, opts ...Option) (c *Config, err error) {
 	c = &Config{
 		name:         name,
 		provider:     cfg,
-		factInterval: 600 * time.Second,
+		factInterval: 5 * time.Second,
+		factDebounce: 250 * time.Millisecond,
+		factHistory:  10,
 		maxStopDelay: 10 * time.Second,
 		opts:         opts,
+		srvResolver:  net.LookupSRV,
+
+		connectTimeout:    10 * time.Second,
+		connectMinBackoff: time.Second,
+		connectMaxBackoff: time.Minute,
+
+		outboxSize:    1000,
+		outboxTimeout: 5 * time.Second,
+		spoolMaxBytes: 100 * 1024 * 1024,
 	}
 
 	if cfg.Name() == "" {
 		return nil, fmt.Errorf("please specify an application name")
 	}
 
+	if !validName.MatchString(cfg.Name()) {
+		return nil, fmt.Errorf("invalid application name %q, names must match ^[a-z0-9]+$", cfg.Name())
+	}
+
 	c.brokers = cfg.MiddlewareHosts()
 	c.appname = fmt.Sprintf("%s_backplane", cfg.Name())
 	c.logfile = cfg.LogFile()
 	c.loglevel = cfg.LogLevel()
 	c.tls = cfg.TLS()
+	c.tlsState = tlsFingerprint(c.tls)
 	c.auth = cfg.Auth()
 
+	if sp, ok := cfg.(SRVConfigProvider); ok {
+		c.srvDomain = sp.SRVDomain()
+	}
+
+	if np, ok := cfg.(NATSConfigProvider); ok {
+		c.nats = np.NATS()
+	}
+
 	for _, opt := range opts {
 		opt(c)
 	}
 
-	if len(c.brokers) == 0 {
-		return nil, fmt.Errorf("please specify backplane brokers")
+	if len(c.brokers) == 0 && c.srvDomain == "" {
+		return nil, fmt.Errorf("please specify backplane brokers or a SRV domain")
 	}
 
-	if c.loglevel == "" {
-		c.loglevel = "warn"
+	err = validateFactSchema(c.factSchema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fact schema: %s", err)
 	}
 
-	ok, err := regexp.MatchString("^[a-z0-9]+_backplane$", c.appname)
-	if !ok || err != nil {
-		return nil, fmt.Errorf("the application name must match ^[a-z0-9]+$")
+	if c.outboxSize < 1 {
+		return nil, fmt.Errorf("the data outbox size must be at least 1")
+	}
+
+	if c.outboxPolicy == BlockWithTimeout && c.outboxTimeout <= 0 {
+		return nil, fmt.Errorf("the data outbox timeout must be positive")
+	}
+
+	if c.outboxPolicy == SpillToDisk && c.outboxDir == "" {
+		return nil, fmt.Errorf("a directory is required to spill the data outbox to disk")
+	}
+
+	if c.spoolMaxBytes < 0 || c.spoolMaxAge < 0 {
+		return nil, fmt.Errorf("the spool limits can not be negative")
+	}
+
+	if c.batchItems < 0 || c.batchBytes < 0 || c.batchDelay < 0 {
+		return nil, fmt.Errorf("the data batch limits can not be negative")
+	}
+
+	if c.batchItems+c.batchBytes > 0 && c.batchDelay == 0 {
+		return nil, fmt.Errorf("a maximum delay is required when batching data")
+	}
+
+	if c.compression != NoCompression && c.batchDelay == 0 {
+		return nil, fmt.Errorf("data compression requires data batching")
+	}
+
+	if c.healthThreshold < 0 {
+		return nil, fmt.Errorf("the connection health threshold can not be negative")
+	}
+
+	if c.connectMinBackoff <= 0 || c.connectMaxBackoff < c.connectMinBackoff {
+		return nil, fmt.Errorf("the connection backoff must be positive with a maximum larger than the minimum")
+	}
+
+	if c.loglevel == "" {
+		c.loglevel = "warn"
 	}
 
 	c.ccfg, err = chconf.NewDefaultConfig()
@@ -133,6 +249,7 @@ func newConfig(name string, cfg ConfigProvider, opts ...Option) (c *Config, err
 	c.ccfg.Choria.UseSRVRecords = false
 	c.ccfg.Choria.MiddlewareHosts = c.brokers
 	c.ccfg.RegistrationCollective = c.appname
+	c.ccfg.RegistrationSplay = false
 
 	if c.tls != nil {
 		c.ccfg.DisableTLS = false
@@ -157,16 +274,55 @@ func newConfig(name string, cfg ConfigProvider, opts ...Option) (c *Config, err
 		}
 	} else {
 		c.ccfg.DisableTLS = true
-		protocol.Secure = "false"
 		c.ccfg.Choria.SecurityProvider = "file"
 	}
 
+	if c.nats != nil {
+		c.ccfg.Choria.NatsUser = c.nats.User
+		c.ccfg.Choria.NatsPass = c.nats.Password
+		c.ccfg.Choria.NatsCredentials = c.nats.Credentials
+	}
+
+	return c, nil
+}
+
+// setupFramework creates the Choria framework and sets the protocol security to match the
+// TLS settings, when logger is given the framework logs using it rather than setting up
+// logging again which would open the log file once more and reset the global logger
+func (c *Config) setupFramework(logger *logrus.Logger) (err error) {
+	if c.tls != nil {
+		protocol.Secure = "true"
+	} else {
+		protocol.Secure = "false"
+	}
+
+	if logger == nil {
+		c.fw, err = choria.NewWithConfig(c.ccfg)
+		return err
+	}
+
+	logfile := c.ccfg.LogFile
+	c.ccfg.LogFile = "discard"
 	c.fw, err = choria.NewWithConfig(c.ccfg)
+	c.ccfg.LogFile = logfile
 	if err != nil {
-		return
+		return err
 	}
 
-	return
+	// loggers taken while the framework was created, like the security provider one, share
+	// the discarded logger so it is pointed at the output of logger
+	created := c.fw.Logger("backplane").Logger
+	created.SetOutput(logger.Out)
+	created.SetFormatter(logger.Formatter)
+	created.SetLevel(logger.GetLevel())
+
+	c.fw.SetLogger(logger)
+
+	logrus.SetOutput(logger.Out)
+	logrus.SetFormatter(logger.Formatter)
+	logrus.SetLevel(logger.GetLevel())
+
+	return nil
 }
 
 // ManageLogLevel supplies a class that can have its log level adjusted
@@ -209,13 +365,37 @@ func ManageInfoSource(f InfoSource) Option {
 	}
 }
 
-// FactWriteInterval is the frequency that facts will be written to disk, 600 seconds is default
-func FactWriteInterval(i time.Duration) Option {
+// FactRefreshInterval is the frequency that facts will be gathered from the InfoSource, 5 seconds is default.
+// Facts are only written to disk when they change, earlier releases wrote them every 600 seconds
+func FactRefreshInterval(i time.Duration) Option {
 	return func(c *Config) {
 		c.factInterval = i
 	}
 }
 
+// FactRefreshDebounce is how long to wait after a refresh was requested using RefreshFacts or a
+// FactNotifier before gathering facts, requests made during this time are combined, 250ms is default
+func FactRefreshDebounce(d time.Duration) Option {
+	return func(c *Config) {
+		c.factDebounce = d
+	}
+}
+
+// FactWriteInterval is the frequency that facts will be gathered from the InfoSource
+//
+// Deprecated: use FactRefreshInterval, facts are now only written when they change
+func FactWriteInterval(i time.Duration) Option {
+	return FactRefreshInterval(i)
+}
+
+// MirrorFacts writes a human readable copy of the facts to file whenever they change, this
+// is intended for debugging and the file is not removed on shutdown
+func MirrorFacts(file string) Option {
+	return func(c *Config) {
+		c.factMirror = file
+	}
+}
+
 // MaxStopDelay is the maximum time to wait before calling stop
 func MaxStopDelay(i time.Duration) Option {
 	return func(c *Config) {
@@ -229,3 +409,34 @@ func StartDataPublisher() Option {
 		c.publishdata = true
 	}
 }
+
+// OnStateChange registers a handler that will be called whenever the connection to
+// the broker changes state, it can be supplied multiple times to register many handlers
+func OnStateChange(h StateChangeHandler) Option {
+	return func(c *Config) {
+		c.stateHandlers = append(c.stateHandlers, h)
+	}
+}
+
+// BackgroundConnect starts the backplane without waiting for the initial connection to the
+// broker, the connection is attempted in the background using an exponential backoff
+func BackgroundConnect() Option {
+	return func(c *Config) {
+		c.backgroundConnect = true
+	}
+}
+
+// ConnectBackoff sets the minimum and maximum delay between background connection attempts, 1 second and 1 minute is default
+func ConnectBackoff(min time.Duration, max time.Duration) Option {
+	return func(c *Config) {
+		c.connectMinBackoff = min
+		c.connectMaxBackoff = max
+	}
+}
+
+// ConnectTimeout is how long a single background connection attempt may take, 10 seconds is default
+func ConnectTimeout(t time.Duration) Option {
+	return func(c *Config) {
+		c.connectTimeout = t
+	}
+}
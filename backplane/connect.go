@@ -0,0 +1,147 @@
+package backplane
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// ConnectionStatus describes the connection to the broker and the attempts made to establish it
+type ConnectionStatus struct {
+	State           string    `json:"state"`
+	Since           time.Time `json:"since"`
+	ConnectedServer string    `json:"connected_server,omitempty"`
+	Attempts        int       `json:"attempts"`
+	LastAttempt     time.Time `json:"last_attempt,omitempty"`
+	NextAttempt     time.Time `json:"next_attempt,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+}
+
+// ConnectionStatus reports the current connection state and the history of connection attempts
+func (m *Management) ConnectionStatus() *ConnectionStatus {
+	m.stateMu.Lock()
+	status := &ConnectionStatus{
+		State:       m.state.String(),
+		Since:       m.stateSince,
+		Attempts:    m.connAttempts,
+		LastAttempt: m.lastAttempt,
+		NextAttempt: m.nextAttempt,
+	}
+
+	if m.lastConnErr != nil {
+		status.LastError = m.lastConnErr.Error()
+	}
+	m.stateMu.Unlock()
+
+	m.mu.Lock()
+	srv := m.cserver
+	m.mu.Unlock()
+
+	if srv != nil && srv.Connector() != nil && srv.Connector().Nats() != nil {
+		status.ConnectedServer = srv.Connector().ConnectedServer()
+	}
+
+	return status
+}
+
+// connect makes a single attempt to start the Choria server and records the outcome
+func (m *Management) connect(ctx context.Context, timeout time.Duration) error {
+	m.stateMu.Lock()
+	m.connAttempts++
+	m.lastAttempt = time.Now()
+	m.nextAttempt = time.Time{}
+	attempt := m.connAttempts
+	m.stateMu.Unlock()
+
+	m.log.Infof("Connecting to the Choria Broker, attempt %d", attempt)
+
+	err := m.startInstance(ctx, timeout)
+
+	m.stateMu.Lock()
+	m.lastConnErr = err
+	m.stateMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	m.setState(Connected)
+
+	return nil
+}
+
+// startBackgroundConnect connects to the broker in the background retrying with
+// an exponential backoff till the connection is established
+func (m *Management) startBackgroundConnect() {
+	ctx, cancel := context.WithCancel(m.ctx)
+	done := make(chan struct{})
+
+	m.mu.Lock()
+	m.stopConnect = func() {
+		cancel()
+		<-done
+	}
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go m.connectLoop(ctx, done)
+}
+
+// stopConnectLoop stops any running background connection attempts and waits for them to finish
+func (m *Management) stopConnectLoop() {
+	m.mu.Lock()
+	stop := m.stopConnect
+	m.stopConnect = nil
+	m.mu.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+}
+
+func (m *Management) connectLoop(ctx context.Context, done chan struct{}) {
+	defer m.wg.Done()
+	defer close(done)
+
+	for try := 0; ; try++ {
+		err := m.connect(ctx, m.cfg.connectTimeout)
+		if err == nil {
+			return
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := m.connectBackoff(try)
+
+		m.stateMu.Lock()
+		m.nextAttempt = time.Now().Add(delay)
+		m.stateMu.Unlock()
+
+		m.log.Warnf("Could not connect to the Choria Broker, retrying in %s: %s", delay.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// connectBackoff is an exponential backoff with jitter between the configured minimum and maximum
+func (m *Management) connectBackoff(try int) time.Duration {
+	d := m.cfg.connectMinBackoff
+	for i := 0; i < try && d < m.cfg.connectMaxBackoff; i++ {
+		d = d * 2
+	}
+
+	if d > m.cfg.connectMaxBackoff {
+		d = m.cfg.connectMaxBackoff
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,146 @@
+package backplane
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvConfiguration implements ConfigProvider using environment variables
+//
+// With the default BACKPLANE prefix the following variables are supported:
+//
+//	BACKPLANE_NAME             the backplane name
+//	BACKPLANE_BROKERS          comma separated list of brokers in host:port format
+//	BACKPLANE_SRV_DOMAIN       domain to query for broker SRV records
+//	BACKPLANE_LOGFILE          the file to log to
+//	BACKPLANE_LOGLEVEL         the level to log at, one of debug, info, warn, error or fatal
+//	BACKPLANE_TLS_SCHEME       puppet or file, when unset TLS is disabled
+//	BACKPLANE_TLS_IDENTITY     the certificate name to use
+//	BACKPLANE_TLS_SSL_DIR      the SSL directory in the puppet scheme
+//	BACKPLANE_TLS_CA           path to the CA in the file scheme
+//	BACKPLANE_TLS_CERT         path to the certificate in the file scheme
+//	BACKPLANE_TLS_KEY          path to the key in the file scheme
+//	BACKPLANE_TLS_CACHE        path to the certificate cache in the file scheme
+//	BACKPLANE_NATS_USER        the user to connect to NATS as
+//	BACKPLANE_NATS_PASSWORD    the password to connect to NATS with
+//	BACKPLANE_NATS_CREDENTIALS path to a NATS 2.0 credentials file
+//	BACKPLANE_AUTH_INSECURE    true to allow all callers to do anything
+//	BACKPLANE_AUTH_FULL        comma separated list of certname regular expressions with full access
+//	BACKPLANE_AUTH_READ_ONLY   comma separated list of certname regular expressions with read only access
+type EnvConfiguration struct {
+	StandardConfiguration
+
+	prefix string
+}
+
+// NewEnvConfiguration reads and validates the backplane configuration from the environment,
+// variable names are prefixed with prefix and an underscore, BACKPLANE when prefix is empty
+func NewEnvConfiguration(prefix string) (*EnvConfiguration, error) {
+	if prefix == "" {
+		prefix = "BACKPLANE"
+	}
+
+	e := &EnvConfiguration{
+		prefix: strings.TrimSuffix(prefix, "_"),
+	}
+
+	err := e.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Prefix is the prefix used for environment variable names
+func (e *EnvConfiguration) Prefix() string {
+	return e.prefix
+}
+
+func (e *EnvConfiguration) key(k string) string {
+	return fmt.Sprintf("%s_%s", e.prefix, k)
+}
+
+func (e *EnvConfiguration) get(k string) string {
+	return strings.TrimSpace(os.Getenv(e.key(k)))
+}
+
+func (e *EnvConfiguration) list(k string) []string {
+	var items []string
+
+	for _, i := range strings.Split(e.get(k), ",") {
+		i = strings.TrimSpace(i)
+		if i != "" {
+			items = append(items, i)
+		}
+	}
+
+	return items
+}
+
+func (e *EnvConfiguration) load() error {
+	var errs []string
+
+	fail := func(k string, format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf("%s %s", e.key(k), fmt.Sprintf(format, a...)))
+	}
+
+	e.AppName = e.get("NAME")
+	e.Brokers = e.list("BROKERS")
+	e.SRVDomainName = e.get("SRV_DOMAIN")
+	e.LogFilePath = e.get("LOGFILE")
+	e.Loglevel = e.get("LOGLEVEL")
+
+	scheme := e.get("TLS_SCHEME")
+	if scheme != "" {
+		e.TLSConf = &TLSConf{
+			Scheme:   scheme,
+			Identity: e.get("TLS_IDENTITY"),
+			SSLDir:   e.get("TLS_SSL_DIR"),
+			CA:       e.get("TLS_CA"),
+			Cert:     e.get("TLS_CERT"),
+			Key:      e.get("TLS_KEY"),
+			Cache:    e.get("TLS_CACHE"),
+		}
+	} else {
+		for _, k := range []string{"TLS_IDENTITY", "TLS_SSL_DIR", "TLS_CA", "TLS_CERT", "TLS_KEY", "TLS_CACHE"} {
+			if e.get(k) != "" {
+				fail(k, "is set but %s is not", e.key("TLS_SCHEME"))
+			}
+		}
+	}
+
+	if user, pass, creds := e.get("NATS_USER"), e.get("NATS_PASSWORD"), e.get("NATS_CREDENTIALS"); user != "" || pass != "" || creds != "" {
+		e.NATSConf = &NATSConf{
+			User:        user,
+			Password:    pass,
+			Credentials: creds,
+		}
+	}
+
+	if insecure := e.get("AUTH_INSECURE"); insecure != "" {
+		b, err := strconv.ParseBool(insecure)
+		if err != nil {
+			fail("AUTH_INSECURE", "must be a boolean, got %q", insecure)
+		}
+
+		e.Authorization.Insecure = b
+	}
+
+	e.Authorization.Full = e.list("AUTH_FULL")
+	e.Authorization.RO = e.list("AUTH_READ_ONLY")
+
+	if err := e.Validate(); err != nil {
+		for _, verr := range err.(ValidationErrors) {
+			errs = append(errs, verr.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid backplane environment configuration: %s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
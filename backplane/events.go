@@ -0,0 +1,113 @@
+package backplane
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+)
+
+const (
+	// CloudEventsVersion is the CloudEvents specification version events are published as
+	CloudEventsVersion = "1.0"
+
+	// JSONDataEventType is the event type used by PublishJSON
+	JSONDataEventType = "io.choria.backplane.v1.data"
+)
+
+// CloudEvent is a CloudEvents 1.0 event in the JSON format as published by PublishJSON
+// and PublishEvent, the identity, application and sequence extensions describe the
+// publishing instance and the order events were published in, the sequence is a decimal
+// string as the CloudEvents sequence extension requires
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	Identity        string          `json:"identity"`
+	Application     string          `json:"application"`
+	Sequence        string          `json:"sequence"`
+}
+
+// EventDestination sets where PublishEvent publishes events, backplane.<name>.events when not set
+func EventDestination(destination string) Option {
+	return func(c *Config) {
+		c.eventsDest = destination
+	}
+}
+
+// PublishJSON publishes v encoded as JSON to destination wrapped in a CloudEvent of type JSONDataEventType
+func (m *Management) PublishJSON(destination string, v interface{}) error {
+	return m.publishCloudEvent(destination, JSONDataEventType, "", v)
+}
+
+// PublishEvent publishes a CloudEvent with data encoded as JSON to the destination set
+// using EventDestination, when source is empty /<name> is used
+func (m *Management) PublishEvent(eventType string, source string, data interface{}) error {
+	if eventType == "" {
+		return fmt.Errorf("an event type is required")
+	}
+
+	destination := m.cfg.eventsDest
+	if destination == "" {
+		destination = fmt.Sprintf("backplane.%s.events", m.service)
+	}
+
+	return m.publishCloudEvent(destination, eventType, source, data)
+}
+
+func (m *Management) publishCloudEvent(destination string, eventType string, source string, data interface{}) error {
+	if destination == "" {
+		return fmt.Errorf("a destination is required")
+	}
+
+	event, err := m.newCloudEvent(eventType, source, data)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %s", err)
+	}
+
+	return m.Publish(&DataItem{Data: j, Destination: destination})
+}
+
+func (m *Management) newCloudEvent(eventType string, source string, data interface{}) (*CloudEvent, error) {
+	id, err := choria.NewRequestID()
+	if err != nil {
+		return nil, fmt.Errorf("could not create event id: %s", err)
+	}
+
+	if source == "" {
+		source = "/" + m.service
+	}
+
+	event := &CloudEvent{
+		SpecVersion:     CloudEventsVersion,
+		ID:              id,
+		Source:          source,
+		Type:            eventType,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Identity:        m.currentIdentity(),
+		Application:     m.service,
+		Sequence:        strconv.FormatUint(atomic.AddUint64(&m.eventSeq, 1), 10),
+	}
+
+	if data != nil {
+		event.Data, err = json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode event data: %s", err)
+		}
+	}
+
+	return event, nil
+}
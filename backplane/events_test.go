@@ -0,0 +1,42 @@
+package backplane
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func TestNewCloudEventSequence(t *testing.T) {
+	m := &Management{service: "test", identity: "test.example.net", mu: &sync.Mutex{}}
+
+	for _, expected := range []string{"1", "2"} {
+		event, err := m.newCloudEvent("com.example.test", "", map[string]string{"hello": "world"})
+		if err != nil {
+			t.Fatalf("could not create event: %s", err)
+		}
+
+		j, err := json.Marshal(event)
+		if err != nil {
+			t.Fatalf("could not encode event: %s", err)
+		}
+
+		raw := map[string]interface{}{}
+		err = json.Unmarshal(j, &raw)
+		if err != nil {
+			t.Fatalf("could not decode event: %s", err)
+		}
+
+		seq, ok := raw["sequence"].(string)
+		if !ok {
+			t.Fatalf("expected sequence to be a string got %T", raw["sequence"])
+		}
+
+		if seq != expected {
+			t.Fatalf("expected sequence %s got %s", expected, seq)
+		}
+
+		if raw["source"] != "/test" {
+			t.Fatalf("expected source /test got %v", raw["source"])
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package backplane
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ArrayFlattening controls how arrays are handled when flattening facts
+type ArrayFlattening int
+
+const (
+	// KeepArrays keeps arrays as they are
+	KeepArrays ArrayFlattening = iota
+
+	// IndexArrays flattens arrays into a key per item named after its index, like ports.0 and ports.1
+	IndexArrays
+
+	// JoinArrays turns arrays of strings, numbers and booleans into a comma separated string,
+	// arrays holding other values are kept as they are
+	JoinArrays
+)
+
+// FactFlattening configures how nested facts are flattened into keys joined by a separator
+type FactFlattening struct {
+	// Separator joins the keys of nested values, "." when empty
+	Separator string
+
+	// MaxDepth is the most keys that will be joined, values nested deeper are kept as they are, 0 is unlimited
+	MaxDepth int
+
+	// Arrays determines how arrays are flattened
+	Arrays ArrayFlattening
+}
+
+// FlattenFacts flattens nested facts so that {"db":{"primary":{"host":"db1"}}} becomes {"db.primary.host":"db1"}.
+//
+// When flattening produces a key that already exists, like {"a.b":1,"a":{"b":2}}, the fact that
+// was not flattened wins, otherwise the value of the first key in sorted order wins
+func FlattenFacts(f FactFlattening) Option {
+	return func(c *Config) {
+		if f.Separator == "" {
+			f.Separator = "."
+		}
+
+		c.flatten = &f
+	}
+}
+
+// flattenedFacts collects the flattened facts and the keys that were produced more than once
+type flattenedFacts struct {
+	out        map[string]interface{}
+	literal    map[string]bool
+	collisions map[string]bool
+}
+
+// set stores value under key, top level keys win over keys produced by flattening and
+// otherwise the first value stored is kept
+func (ff *flattenedFacts) set(key string, value interface{}, depth int) {
+	if _, ok := ff.out[key]; ok {
+		ff.collisions[key] = true
+
+		if ff.literal[key] || depth > 1 {
+			return
+		}
+	}
+
+	ff.out[key] = value
+	ff.literal[key] = depth == 1
+}
+
+// flatten flattens in and returns the keys that more than one fact was flattened into, sorted
+func (f *FactFlattening) flatten(in map[string]interface{}) (map[string]interface{}, []string) {
+	ff := &flattenedFacts{
+		out:        make(map[string]interface{}),
+		literal:    make(map[string]bool),
+		collisions: make(map[string]bool),
+	}
+
+	for _, k := range sortedKeys(in) {
+		f.flattenValue(k, in[k], 1, ff)
+	}
+
+	collisions := []string{}
+	for k := range ff.collisions {
+		collisions = append(collisions, k)
+	}
+	sort.Strings(collisions)
+
+	return ff.out, collisions
+}
+
+func (f *FactFlattening) flattenValue(key string, value interface{}, depth int, out *flattenedFacts) {
+	if f.MaxDepth > 0 && depth >= f.MaxDepth {
+		out.set(key, value, depth)
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			out.set(key, v, depth)
+			return
+		}
+
+		for _, k := range sortedKeys(v) {
+			f.flattenValue(key+f.Separator+k, v[k], depth+1, out)
+		}
+
+	case []interface{}:
+		switch f.Arrays {
+		case IndexArrays:
+			if len(v) == 0 {
+				out.set(key, v, depth)
+				return
+			}
+
+			for i, item := range v {
+				f.flattenValue(key+f.Separator+strconv.Itoa(i), item, depth+1, out)
+			}
+
+		case JoinArrays:
+			if joined, ok := joinScalars(v); ok {
+				out.set(key, joined, depth)
+				return
+			}
+
+			out.set(key, v, depth)
+
+		default:
+			out.set(key, v, depth)
+		}
+
+	default:
+		out.set(key, v, depth)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// joinScalars joins items into a comma separated string, false when an item is not a scalar
+func joinScalars(items []interface{}) (string, bool) {
+	parts := make([]string, len(items))
+
+	for i, item := range items {
+		switch v := item.(type) {
+		case string:
+			parts[i] = v
+		case float64:
+			parts[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			parts[i] = strconv.FormatBool(v)
+		default:
+			return "", false
+		}
+	}
+
+	return strings.Join(parts, ","), true
+}
@@ -0,0 +1,128 @@
+package backplane
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// readmeFacts are the facts used in the README example of FlattenFacts
+const readmeFacts = `{"db":{"primary":{"host":"db1","ports":[5432,5433]}}}`
+
+func flattenJSON(t *testing.T, f FactFlattening, facts string) map[string]interface{} {
+	t.Helper()
+
+	in := make(map[string]interface{})
+	err := json.Unmarshal([]byte(facts), &in)
+	if err != nil {
+		t.Fatalf("could not parse facts: %s", err)
+	}
+
+	c := &Config{}
+	FlattenFacts(f)(c)
+
+	out, _ := c.flatten.flatten(in)
+
+	return out
+}
+
+func TestFlattenFactsReadmeExample(t *testing.T) {
+	out := flattenJSON(t, FactFlattening{Separator: "_", MaxDepth: 4, Arrays: IndexArrays}, readmeFacts)
+
+	expected := map[string]interface{}{
+		"db_primary_host":    "db1",
+		"db_primary_ports_0": float64(5432),
+		"db_primary_ports_1": float64(5433),
+	}
+
+	if !reflect.DeepEqual(out, expected) {
+		t.Fatalf("expected %v got %v", expected, out)
+	}
+}
+
+func TestFlattenFactsJoinArrays(t *testing.T) {
+	out := flattenJSON(t, FactFlattening{Separator: "_", MaxDepth: 4, Arrays: JoinArrays}, readmeFacts)
+
+	expected := map[string]interface{}{
+		"db_primary_host":  "db1",
+		"db_primary_ports": "5432,5433",
+	}
+
+	if !reflect.DeepEqual(out, expected) {
+		t.Fatalf("expected %v got %v", expected, out)
+	}
+}
+
+func TestFlattenFactsMaxDepth(t *testing.T) {
+	out := flattenJSON(t, FactFlattening{Separator: "_", MaxDepth: 3, Arrays: IndexArrays}, readmeFacts)
+
+	expected := map[string]interface{}{
+		"db_primary_host":  "db1",
+		"db_primary_ports": []interface{}{float64(5432), float64(5433)},
+	}
+
+	if !reflect.DeepEqual(out, expected) {
+		t.Fatalf("expected %v got %v", expected, out)
+	}
+
+	out = flattenJSON(t, FactFlattening{MaxDepth: 2}, readmeFacts)
+
+	expected = map[string]interface{}{
+		"db.primary": map[string]interface{}{
+			"host":  "db1",
+			"ports": []interface{}{float64(5432), float64(5433)},
+		},
+	}
+
+	if !reflect.DeepEqual(out, expected) {
+		t.Fatalf("expected %v got %v", expected, out)
+	}
+}
+
+func TestFlattenFactsCollisions(t *testing.T) {
+	cases := []struct {
+		facts      string
+		expected   map[string]interface{}
+		collisions []string
+	}{
+		{
+			facts:      `{"a.b":1,"a":{"b":2}}`,
+			expected:   map[string]interface{}{"a.b": float64(1)},
+			collisions: []string{"a.b"},
+		},
+		{
+			facts:      `{"a":{"b.c":1},"a.b":{"c":2}}`,
+			expected:   map[string]interface{}{"a.b.c": float64(1)},
+			collisions: []string{"a.b.c"},
+		},
+		{
+			facts:      `{"a":{"b":1},"c":2}`,
+			expected:   map[string]interface{}{"a.b": float64(1), "c": float64(2)},
+			collisions: []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		in := make(map[string]interface{})
+		err := json.Unmarshal([]byte(tc.facts), &in)
+		if err != nil {
+			t.Fatalf("could not parse facts: %s", err)
+		}
+
+		c := &Config{}
+		FlattenFacts(FactFlattening{})(c)
+
+		// map iteration order is random so flatten repeatedly to show the winner does not change
+		for i := 0; i < 20; i++ {
+			out, collisions := c.flatten.flatten(in)
+
+			if !reflect.DeepEqual(out, tc.expected) {
+				t.Fatalf("%s: expected %v got %v", tc.facts, tc.expected, out)
+			}
+
+			if !reflect.DeepEqual(collisions, tc.collisions) {
+				t.Fatalf("%s: expected collisions %v got %v", tc.facts, tc.collisions, collisions)
+			}
+		}
+	}
+}
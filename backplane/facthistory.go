@@ -0,0 +1,188 @@
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/providers/agent/mcorpc"
+)
+
+// FactChange describes how the facts changed between two times they were gathered
+type FactChange struct {
+	// Service is the name of the managed service
+	Service string `json:"service"`
+
+	// Identity is the Choria identity of the instance
+	Identity string `json:"identity"`
+
+	// Time is when the change was detected
+	Time time.Time `json:"time"`
+
+	// Added holds facts that did not exist before
+	Added map[string]interface{} `json:"added,omitempty"`
+
+	// Removed holds facts that no longer exist with their previous values
+	Removed map[string]interface{} `json:"removed,omitempty"`
+
+	// Changed holds facts that exist before and after with different values
+	Changed map[string]FactValueChange `json:"changed,omitempty"`
+}
+
+// FactValueChange is the previous and new value of a changed fact
+type FactValueChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// FactChangeHandler is called whenever facts change
+type FactChangeHandler func(change FactChange)
+
+// FactHistoryReply is the reply from the facthistory action
+type FactHistoryReply struct {
+	Changes []FactChange `json:"changes"`
+}
+
+// FactHistorySize is how many fact changes to keep for the facthistory action, 10 is default
+func FactHistorySize(n int) Option {
+	return func(c *Config) {
+		c.factHistory = n
+	}
+}
+
+// OnFactChange registers a handler that will be called whenever facts change, it can be
+// supplied multiple times to register many handlers
+func OnFactChange(h FactChangeHandler) Option {
+	return func(c *Config) {
+		c.factHandlers = append(c.factHandlers, h)
+	}
+}
+
+// PublishFactChanges publishes every fact change as JSON to destination using the data
+// publisher, backplane.<name>.facts when destination is empty
+func PublishFactChanges(destination string) Option {
+	return func(c *Config) {
+		c.publishdata = true
+		c.publishFacts = true
+		c.factsDest = destination
+	}
+}
+
+// FactHistory is the most recent fact changes, oldest first
+func (m *Management) FactHistory() []FactChange {
+	m.factsMu.Lock()
+	defer m.factsMu.Unlock()
+
+	return append([]FactChange{}, m.history...)
+}
+
+// diffFacts compares the previously gathered facts with new ones, nil when there are no previous
+// facts. Both are compared as decoded from JSON so that, for example, an int and the float64 it
+// decodes to are not reported as a change
+func (m *Management) diffFacts(previous []byte, facts map[string]interface{}) (*FactChange, error) {
+	if previous == nil {
+		return nil, nil
+	}
+
+	old, err := normalizeFacts(previous)
+	if err != nil {
+		return nil, err
+	}
+
+	cj, err := json.Marshal(facts)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := normalizeFacts(cj)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &FactChange{
+		Service:  m.service,
+		Identity: m.currentIdentity(),
+		Time:     time.Now().UTC(),
+		Added:    make(map[string]interface{}),
+		Removed:  make(map[string]interface{}),
+		Changed:  make(map[string]FactValueChange),
+	}
+
+	for k, v := range current {
+		ov, ok := old[k]
+		switch {
+		case !ok:
+			change.Added[k] = v
+		case !reflect.DeepEqual(ov, v):
+			change.Changed[k] = FactValueChange{Old: ov, New: v}
+		}
+	}
+
+	for k, v := range old {
+		if _, ok := current[k]; !ok {
+			change.Removed[k] = v
+		}
+	}
+
+	return change, nil
+}
+
+func normalizeFacts(j []byte) (map[string]interface{}, error) {
+	facts := make(map[string]interface{})
+	err := json.Unmarshal(j, &facts)
+	if err != nil {
+		return nil, err
+	}
+
+	return facts, nil
+}
+
+// recordFactChange adds change to the history, factsMu should be held
+func (m *Management) recordFactChange(change *FactChange) {
+	if m.cfg.factHistory <= 0 {
+		return
+	}
+
+	m.history = append(m.history, *change)
+	if len(m.history) > m.cfg.factHistory {
+		m.history = m.history[len(m.history)-m.cfg.factHistory:]
+	}
+}
+
+// notifyFactChange calls the change handlers and publishes the change, factsMu should not be held
+func (m *Management) notifyFactChange(change *FactChange) {
+	m.log.Infof("Facts changed: %d added, %d removed and %d changed", len(change.Added), len(change.Removed), len(change.Changed))
+
+	for _, h := range m.cfg.factHandlers {
+		h(*change)
+	}
+
+	if !m.cfg.publishFacts {
+		return
+	}
+
+	j, err := json.Marshal(change)
+	if err != nil {
+		m.log.Errorf("Could not encode fact change: %s", err)
+		return
+	}
+
+	destination := m.cfg.factsDest
+	if destination == "" {
+		destination = fmt.Sprintf("backplane.%s.facts", m.service)
+	}
+
+	err = m.Publish(&DataItem{Data: j, Destination: destination})
+	if err != nil {
+		m.log.Warnf("Could not publish fact change to %s: %s", destination, err)
+	}
+}
+
+func (m *Management) factHistoryAction(ctx context.Context, req *mcorpc.Request, reply *mcorpc.Reply, agent *mcorpc.Agent, conn inter.ConnectorInfo) {
+	reply.Data = &FactHistoryReply{
+		Changes: m.FactHistory(),
+	}
+}
@@ -0,0 +1,63 @@
+package backplane
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func TestDiffFactsIntegerFacts(t *testing.T) {
+	m := &Management{service: "test", identity: "test.example.net", mu: &sync.Mutex{}}
+
+	previous := map[string]interface{}{
+		"backplane_pid": 1234,
+		"workers":       int64(10),
+		"ratio":         0.5,
+		"region":        "eu-west-1",
+		"limits":        map[string]interface{}{"max": 100},
+	}
+
+	pj, err := json.Marshal(previous)
+	if err != nil {
+		t.Fatalf("could not encode facts: %s", err)
+	}
+
+	current := map[string]interface{}{
+		"backplane_pid": 1234,
+		"workers":       int64(10),
+		"ratio":         0.5,
+		"region":        "eu-central-1",
+		"limits":        map[string]interface{}{"max": 100},
+	}
+
+	change, err := m.diffFacts(pj, current)
+	if err != nil {
+		t.Fatalf("could not compare facts: %s", err)
+	}
+
+	if len(change.Added) != 0 || len(change.Removed) != 0 {
+		t.Fatalf("expected no added or removed facts got %v and %v", change.Added, change.Removed)
+	}
+
+	if len(change.Changed) != 1 {
+		t.Fatalf("expected only region to change got %v", change.Changed)
+	}
+
+	region, ok := change.Changed["region"]
+	if !ok || region.Old != "eu-west-1" || region.New != "eu-central-1" {
+		t.Fatalf("expected region to change from eu-west-1 to eu-central-1 got %v", change.Changed)
+	}
+}
+
+func TestDiffFactsWithoutPrevious(t *testing.T) {
+	m := &Management{mu: &sync.Mutex{}}
+
+	change, err := m.diffFacts(nil, map[string]interface{}{"backplane_pid": 1})
+	if err != nil {
+		t.Fatalf("could not compare facts: %s", err)
+	}
+
+	if change != nil {
+		t.Fatalf("expected no change without previous facts got %v", change)
+	}
+}
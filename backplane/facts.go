@@ -1,12 +1,18 @@
 package backplane
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/choria-io/go-choria/build"
@@ -22,55 +28,191 @@ type InfoSource interface {
 	Version() string
 }
 
+// FactNotifier can optionally be implemented by an InfoSource to signal that its facts
+// changed, the facts will then be refreshed without waiting for the refresh interval
+type FactNotifier interface {
+	// FactsChanged is a channel that receives a value whenever the facts changed
+	FactsChanged() <-chan struct{}
+}
+
+// RefreshFacts requests that facts be gathered without waiting for the
+// refresh interval, it does not block and requests made in quick succession are combined
+func (m *Management) RefreshFacts() {
+	select {
+	case m.factsReq <- struct{}{}:
+	default:
+	}
+}
+
+// Facts is the most recent fact data, the backplane_* facts and those gathered from the InfoSource when one is managed
+func (m *Management) Facts() map[string]interface{} {
+	m.factsMu.Lock()
+	defer m.factsMu.Unlock()
+
+	if m.factsJSON == nil {
+		return nil
+	}
+
+	// returns a copy so callers can not modify the facts being served
+	out := make(map[string]interface{})
+	json.Unmarshal(m.factsJSON, &out)
+
+	return out
+}
+
+// exposeFacts gathers the initial facts and starts keeping them up to date, the
+// returned file is where Choria discovery will find the facts
 func (m *Management) exposeFacts(ctx context.Context, wg *sync.WaitGroup) (f string, err error) {
-	tf, err := ioutil.TempFile("", m.cfg.name+"-choria-backplane-facts")
+	m.cleanStaleFactFiles()
+
+	m.factsFile = m.factFileName(os.Getpid())
+
+	_, err = m.refreshFacts()
 	if err != nil {
-		return "", fmt.Errorf("could not create temporary facts file: %s", err)
+		return "", fmt.Errorf("could not gather initial facts: %s", err)
 	}
-	tf.Close()
 
 	wg.Add(1)
-	go m.fsWriter(ctx, wg, m.cfg.infosource, tf.Name())
+	go m.factRefresher(ctx, wg)
 
-	return tf.Name(), nil
+	return m.factsFile, nil
 }
 
-func (m *Management) fsWriter(ctx context.Context, wg *sync.WaitGroup, fs InfoSource, target string) {
+// factRefresher gathers facts every factInterval and factDebounce after
+// a refresh was requested, facts are only written when they change so this is cheap enough
+// to do every few seconds
+func (m *Management) factRefresher(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
-	defer os.Remove(target)
+	defer os.Remove(m.factsFile)
 
-	writer := func() {
-		err := m.write(fs, target)
-		if err != nil {
-			m.log.Errorf("Could not write fact data to %s: %s", target, err)
-		}
+	m.log.Infof("Gathering fact data every %s, discovery data is written to the temporary file %s when it changes", m.cfg.factInterval, m.factsFile)
+
+	ticker := time.NewTicker(m.cfg.factInterval)
+	defer ticker.Stop()
+
+	var notify <-chan struct{}
+	if n, ok := m.cfg.infosource.(FactNotifier); ok {
+		notify = n.FactsChanged()
 	}
 
-	m.log.Infof("Writing management interface fact data to %s", target)
+	// nil until a refresh is requested, further requests before it fires are combined
+	var debounce <-chan time.Time
+
+	refresh := func() {
+		change, err := m.refreshFacts()
+		if _, ok := err.(*factSchemaError); ok {
+			// already logged once for every fact that does not match
+			return
+		}
+		if err != nil {
+			m.log.Errorf("Could not refresh fact data: %s", err)
+			return
+		}
 
-	m.factsMu = &sync.Mutex{}
+		if change != nil {
+			m.notifyFactChange(change)
+		}
+	}
 
-	writer()
+	request := func() {
+		if debounce == nil {
+			debounce = time.After(m.cfg.factDebounce)
+		}
+	}
 
 	for {
 		select {
-		case <-time.Tick(m.cfg.factInterval):
-			writer()
+		case <-ticker.C:
+			refresh()
+
+		case <-m.factsReq:
+			request()
+
+		case _, ok := <-notify:
+			if !ok {
+				notify = nil
+				continue
+			}
+
+			request()
+
+		case <-debounce:
+			debounce = nil
+			refresh()
+
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (m *Management) convertFacts(fs InfoSource) (out map[string]interface{}, err error) {
-	in, err := json.Marshal(fs.FactData())
+// refreshFacts gathers the facts and, when they changed since the
+// previous refresh, updates the in memory copy, the discovery file, the mirror and the
+// history, the change is nil for the first refresh or when nothing changed
+func (m *Management) refreshFacts() (*FactChange, error) {
+	m.factsMu.Lock()
+	defer m.factsMu.Unlock()
+
+	facts, err := m.convertFacts(m.cfg.infosource)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	err = json.Unmarshal(in, &out)
+	j, err := json.Marshal(facts)
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	if bytes.Equal(j, m.factsJSON) {
+		return nil, nil
+	}
+
+	m.log.Debugf("Fact data changed, updating %d bytes of facts", len(j))
+
+	change, err := m.diffFacts(m.factsJSON, facts)
+	if err != nil {
+		return nil, fmt.Errorf("could not compare facts: %s", err)
+	}
+
+	// only updated once written so a failed write is retried on the next refresh
+	err = writeFile(m.factsFile, j)
+	if err != nil {
+		return nil, fmt.Errorf("could not write fact data to %s: %s", m.factsFile, err)
+	}
+
+	m.factsJSON = j
+
+	if change != nil {
+		m.recordFactChange(change)
+	}
+
+	if m.cfg.factMirror != "" {
+		ij, err := json.MarshalIndent(facts, "", "  ")
+		if err != nil {
+			return change, err
+		}
+
+		err = writeFile(m.cfg.factMirror, ij)
+		if err != nil {
+			return change, fmt.Errorf("could not write fact data mirror to %s: %s", m.cfg.factMirror, err)
+		}
+	}
+
+	return change, nil
+}
+
+func (m *Management) convertFacts(fs InfoSource) (out map[string]interface{}, err error) {
+	out = make(map[string]interface{})
+
+	if fs != nil {
+		out, err = m.appFacts(fs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for k, v := range m.stdFacts {
+		out[k] = v
 	}
 
 	out["backplane_version"] = build.Version
@@ -80,44 +222,124 @@ func (m *Management) convertFacts(fs InfoSource) (out map[string]interface{}, er
 	out["backplane_healthcheckable"] = m.cfg.healthcheckable != nil
 	out["backplane_loglevelsetable"] = m.cfg.logsetable != nil
 
-	return
+	return out, nil
 }
 
-func (m *Management) write(fs InfoSource, target string) error {
-	m.factsMu.Lock()
-	defer m.factsMu.Unlock()
+// appFacts gathers the facts from the InfoSource and checks them
+func (m *Management) appFacts(fs InfoSource) (out map[string]interface{}, err error) {
+	in, err := json.Marshal(fs.FactData())
+	if err != nil {
+		return nil, fmt.Errorf("could not encode fact data: %s", err)
+	}
+
+	var data interface{}
+	err = json.Unmarshal(in, &data)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode fact data: %s", err)
+	}
+
+	switch d := data.(type) {
+	case map[string]interface{}:
+		out = d
+	case nil:
+		out = make(map[string]interface{})
+	default:
+		return nil, fmt.Errorf("fact data must be a JSON object but the InfoSource returned JSON of type %s", factType(d))
+	}
+
+	if m.cfg.flatten != nil {
+		var collisions []string
+		out, collisions = m.cfg.flatten.flatten(out)
 
-	m.log.Debugf("Starting fact source dump to %s", target)
+		for _, k := range collisions {
+			if !m.warned["flatten:"+k] {
+				m.log.Warnf("More than one fact was flattened into %s, only one of them is exposed", k)
+				m.warned["flatten:"+k] = true
+			}
+		}
+	}
 
-	facts, err := m.convertFacts(fs)
+	err = m.protectReservedFacts(out)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	j, err := json.Marshal(facts)
+	err = checkFactSchema(m.cfg.factSchema, out)
+	if serr, ok := err.(*factSchemaError); ok {
+		m.warnFactSchema(serr)
+		return nil, serr
+	}
+
+	return out, nil
+}
+
+func (m *Management) factFilePrefix() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("choria-%s-", m.cfg.appname))
+}
+
+func (m *Management) factFileName(pid int) string {
+	return fmt.Sprintf("%s%d.json", m.factFilePrefix(), pid)
+}
+
+// cleanStaleFactFiles removes discovery files left behind by processes that were killed
+func (m *Management) cleanStaleFactFiles() {
+	// os.Process.Signal can not be used to check for running processes on windows
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	prefix := m.factFilePrefix()
+
+	files, err := filepath.Glob(prefix + "*.json")
 	if err != nil {
-		return err
+		return
+	}
+
+	for _, file := range files {
+		pid, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(file, prefix), ".json"))
+		if err != nil || pid == os.Getpid() || processRunning(pid) {
+			continue
+		}
+
+		m.log.Infof("Removing stale fact data %s left behind by process %d", file, pid)
+		os.Remove(file)
+	}
+}
+
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
 	}
 
-	tf, err := ioutil.TempFile("", "")
+	err = p.Signal(syscall.Signal(0))
+
+	return err == nil || err == syscall.EPERM
+}
+
+// writeFile atomically replaces target with data
+func writeFile(target string, data []byte) error {
+	tf, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target))
 	if err != nil {
 		return err
 	}
 	defer os.Remove(tf.Name())
 
-	tf.Close()
-
-	err = ioutil.WriteFile(tf.Name(), j, 0644)
+	_, err = tf.Write(data)
 	if err != nil {
+		tf.Close()
 		return err
 	}
 
-	err = os.Rename(tf.Name(), target)
+	err = tf.Close()
 	if err != nil {
 		return err
 	}
 
-	m.log.Debugf("Completed %d bytes fact source dump to %s", len(j), target)
+	err = os.Chmod(tf.Name(), 0644)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return os.Rename(tf.Name(), target)
 }
@@ -0,0 +1,248 @@
+package backplane
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/providers/agent/mcorpc"
+)
+
+// reservedFactPrefix is the prefix of facts set by the backplane itself
+const reservedFactPrefix = "backplane_"
+
+// FactType is the type of a fact described in a fact schema
+type FactType string
+
+const (
+	// StringFact is a string fact
+	StringFact FactType = "string"
+
+	// NumberFact is an integer or float fact
+	NumberFact FactType = "number"
+
+	// BooleanFact is a true or false fact
+	BooleanFact FactType = "boolean"
+
+	// ArrayFact is a fact holding a list of values
+	ArrayFact FactType = "array"
+
+	// ObjectFact is a fact holding nested facts
+	ObjectFact FactType = "object"
+)
+
+// FactDescription describes a single fact
+type FactDescription struct {
+	// Name is the name of the fact, after flattening when FlattenFacts is used
+	Name string `json:"name"`
+
+	// Type is the type values of this fact must have
+	Type FactType `json:"type"`
+
+	// Description describes the meaning of the fact
+	Description string `json:"description"`
+}
+
+// FactSchemaReply is the reply from the factschema action
+type FactSchemaReply struct {
+	Facts []FactDescription `json:"facts"`
+}
+
+// builtinFacts describes the facts set by the backplane
+var builtinFacts = []FactDescription{
+	{Name: "backplane_version", Type: StringFact, Description: "The version of the Choria Backplane system in use"},
+	{Name: "backplane_name", Type: StringFact, Description: "The name of the backplane agent"},
+	{Name: "backplane_pausable", Type: BooleanFact, Description: "If the Pausable interface is used"},
+	{Name: "backplane_stopable", Type: BooleanFact, Description: "If the Stopable interface is used"},
+	{Name: "backplane_healthcheckable", Type: BooleanFact, Description: "If the HealthCheckable interface is used"},
+	{Name: "backplane_loglevelsetable", Type: BooleanFact, Description: "If the LogLevelSetable interface is used"},
+	{Name: "backplane_go_version", Type: StringFact, Description: "The Go version the application was built with"},
+	{Name: "backplane_os", Type: StringFact, Description: "The operating system the application runs on"},
+	{Name: "backplane_arch", Type: StringFact, Description: "The CPU architecture the application runs on"},
+	{Name: "backplane_hostname", Type: StringFact, Description: "The hostname of the machine or container"},
+	{Name: "backplane_pid", Type: NumberFact, Description: "The process ID of the application"},
+	{Name: "backplane_start_time", Type: StringFact, Description: "The time the backplane started in RFC3339 format"},
+	{Name: "backplane_module", Type: StringFact, Description: "The module path of the application binary"},
+	{Name: "backplane_module_version", Type: StringFact, Description: "The module version of the application binary"},
+	{Name: "backplane_vcs_revision", Type: StringFact, Description: "The VCS revision the application was built from"},
+	{Name: "backplane_vcs_time", Type: StringFact, Description: "The time of the VCS revision the application was built from"},
+	{Name: "backplane_vcs_modified", Type: BooleanFact, Description: "If the application was built from a modified source tree"},
+	{Name: "backplane_container", Type: BooleanFact, Description: "If the application runs in a Docker or Podman container"},
+	{Name: "backplane_kubernetes", Type: BooleanFact, Description: "If the application runs in Kubernetes"},
+	{Name: "backplane_k8s_pod_name", Type: StringFact, Description: "The Kubernetes pod name from the POD_NAME environment variable"},
+	{Name: "backplane_k8s_namespace", Type: StringFact, Description: "The Kubernetes namespace from the POD_NAMESPACE environment variable"},
+	{Name: "backplane_k8s_node_name", Type: StringFact, Description: "The Kubernetes node name from the NODE_NAME environment variable"},
+	{Name: "backplane_k8s_pod_ip", Type: StringFact, Description: "The Kubernetes pod IP from the POD_IP environment variable"},
+	{Name: "backplane_k8s_service_account", Type: StringFact, Description: "The Kubernetes service account from the POD_SERVICE_ACCOUNT environment variable"},
+}
+
+// DescribeFacts supplies a schema describing the facts of the InfoSource, facts are checked
+// against it whenever they are gathered and it can be retrieved using the factschema action
+func DescribeFacts(facts ...FactDescription) Option {
+	return func(c *Config) {
+		c.factSchema = append(c.factSchema, facts...)
+	}
+}
+
+// RejectReservedFacts fails fact gathering when the InfoSource supplies facts starting with
+// backplane_, by default such facts are renamed with an app_ prefix
+func RejectReservedFacts() Option {
+	return func(c *Config) {
+		c.rejectFacts = true
+	}
+}
+
+func validateFactSchema(schema []FactDescription) error {
+	var errs ValidationErrors
+
+	seen := make(map[string]bool)
+
+	for _, f := range schema {
+		switch {
+		case f.Name == "":
+			errs = append(errs, fmt.Errorf("fact schema entries require a name"))
+			continue
+		case strings.HasPrefix(f.Name, reservedFactPrefix):
+			errs = append(errs, fmt.Errorf("fact %s uses the reserved %s prefix", f.Name, reservedFactPrefix))
+		case seen[f.Name]:
+			errs = append(errs, fmt.Errorf("fact %s is described more than once", f.Name))
+		}
+
+		seen[f.Name] = true
+
+		switch f.Type {
+		case StringFact, NumberFact, BooleanFact, ArrayFact, ObjectFact:
+		default:
+			errs = append(errs, fmt.Errorf("fact %s has invalid type %q, valid types are string, number, boolean, array and object", f.Name, f.Type))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// factSchemaError is returned when facts do not match the fact schema, errs holds a problem
+// for every fact in facts
+type factSchemaError struct {
+	facts []string
+	errs  ValidationErrors
+}
+
+// Error implements error
+func (e *factSchemaError) Error() string {
+	return fmt.Sprintf("facts do not match the schema: %s", e.errs)
+}
+
+// checkFactSchema ensures facts present in facts have the type the schema describes, facts
+// that are absent or not described are not checked
+func checkFactSchema(schema []FactDescription, facts map[string]interface{}) error {
+	serr := &factSchemaError{}
+
+	for _, f := range schema {
+		v, ok := facts[f.Name]
+		if !ok || v == nil {
+			continue
+		}
+
+		actual := factType(v)
+		if actual != f.Type {
+			serr.facts = append(serr.facts, f.Name)
+			serr.errs = append(serr.errs, fmt.Errorf("fact %s should be a %s but is a %s", f.Name, f.Type, actual))
+		}
+	}
+
+	if len(serr.errs) > 0 {
+		return serr
+	}
+
+	return nil
+}
+
+// warnFactSchema logs every fact that does not match the schema once rather than on every refresh
+func (m *Management) warnFactSchema(serr *factSchemaError) {
+	for i, name := range serr.facts {
+		key := "schema:" + serr.errs[i].Error()
+		if m.warned[key] {
+			continue
+		}
+
+		m.log.Errorf("Could not refresh fact data, fact %s does not match the schema: %s", name, serr.errs[i])
+		m.warned[key] = true
+	}
+}
+
+// factType determines the type of a value decoded from JSON
+func factType(v interface{}) FactType {
+	switch v.(type) {
+	case string:
+		return StringFact
+	case float64:
+		return NumberFact
+	case bool:
+		return BooleanFact
+	case []interface{}:
+		return ArrayFact
+	case map[string]interface{}:
+		return ObjectFact
+	case nil:
+		return "null"
+	default:
+		return FactType(fmt.Sprintf("%T", v))
+	}
+}
+
+// protectReservedFacts renames or rejects facts from the InfoSource using the reserved prefix
+func (m *Management) protectReservedFacts(facts map[string]interface{}) error {
+	var reserved []string
+	for k := range facts {
+		if strings.HasPrefix(k, reservedFactPrefix) {
+			reserved = append(reserved, k)
+		}
+	}
+
+	if len(reserved) == 0 {
+		return nil
+	}
+
+	sort.Strings(reserved)
+
+	if m.cfg.rejectFacts {
+		return fmt.Errorf("facts %s use the reserved %s prefix", strings.Join(reserved, ", "), reservedFactPrefix)
+	}
+
+	for _, k := range reserved {
+		// facts supplied by the InfoSource are never overwritten, a number is added to the name instead
+		name := "app_" + k
+		for i := 2; ; i++ {
+			if _, ok := facts[name]; !ok {
+				break
+			}
+
+			name = fmt.Sprintf("app_%s_%d", k, i)
+		}
+
+		if !m.warned[k] {
+			m.log.Warnf("Fact %s uses the reserved %s prefix, exposing it as %s", k, reservedFactPrefix, name)
+			m.warned[k] = true
+		}
+
+		facts[name] = facts[k]
+		delete(facts, k)
+	}
+
+	return nil
+}
+
+func (m *Management) factSchemaAction(ctx context.Context, req *mcorpc.Request, reply *mcorpc.Reply, agent *mcorpc.Agent, conn inter.ConnectorInfo) {
+	schema := append([]FactDescription{}, builtinFacts...)
+	schema = append(schema, m.cfg.factSchema...)
+
+	reply.Data = &FactSchemaReply{
+		Facts: schema,
+	}
+}
@@ -0,0 +1,78 @@
+package backplane
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func factSchemaTestManagement(schema ...FactDescription) (*Management, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+
+	logger := logrus.New()
+	logger.SetOutput(out)
+
+	return &Management{
+		cfg:    &Config{factSchema: schema},
+		log:    logrus.NewEntry(logger),
+		warned: make(map[string]bool),
+	}, out
+}
+
+func TestProtectReservedFactsKeepsExistingFacts(t *testing.T) {
+	m, _ := factSchemaTestManagement()
+
+	facts := map[string]interface{}{
+		"backplane_name":       "mine",
+		"app_backplane_name":   "existing",
+		"backplane_name_2":     "other",
+		"app_backplane_name_2": "taken",
+	}
+
+	err := m.protectReservedFacts(facts)
+	if err != nil {
+		t.Fatalf("could not protect facts: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"app_backplane_name":     "existing",
+		"app_backplane_name_2":   "taken",
+		"app_backplane_name_3":   "mine",
+		"app_backplane_name_2_2": "other",
+	}
+
+	if !reflect.DeepEqual(facts, expected) {
+		t.Fatalf("expected %v got %v", expected, facts)
+	}
+}
+
+func TestFactSchemaMismatchLoggedOnce(t *testing.T) {
+	m, logs := factSchemaTestManagement(FactDescription{Name: "interval", Type: NumberFact})
+
+	facts := map[string]interface{}{"interval": "10"}
+
+	for i := 0; i < 3; i++ {
+		err := checkFactSchema(m.cfg.factSchema, facts)
+		serr, ok := err.(*factSchemaError)
+		if !ok {
+			t.Fatalf("expected a schema error got %v", err)
+		}
+
+		m.warnFactSchema(serr)
+	}
+
+	if c := strings.Count(logs.String(), "fact interval does not match the schema"); c != 1 {
+		t.Fatalf("expected the mismatch to be logged once, logged %d times", c)
+	}
+
+	// a different mismatch of the same fact is logged again
+	facts["interval"] = true
+	m.warnFactSchema(checkFactSchema(m.cfg.factSchema, facts).(*factSchemaError))
+
+	if c := strings.Count(logs.String(), "fact interval does not match the schema"); c != 2 {
+		t.Fatalf("expected the new mismatch to be logged, logged %d times", c)
+	}
+}
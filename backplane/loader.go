@@ -0,0 +1,448 @@
+package backplane
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// loaderKeys are all the keys a Loader knows about in display order
+var loaderKeys = []string{
+	"name",
+	"brokers",
+	"srv_domain",
+	"logfile",
+	"loglevel",
+	"tls.scheme",
+	"tls.identity",
+	"tls.ssl_dir",
+	"tls.ca",
+	"tls.cert",
+	"tls.key",
+	"tls.cache",
+	"nats.user",
+	"nats.password",
+	"nats.credentials",
+	"auth.insecure",
+	"auth.full",
+	"auth.read_only",
+}
+
+// DefaultSource is the source reported for keys that were not set by any source
+const DefaultSource = "default"
+
+// Loader builds a StandardConfiguration from files, environment variables and command line flags
+//
+// Sources are applied in a fixed order with later sources overriding earlier ones: files in the
+// order they were added, then the environment and finally command line flags.  Keys are named
+// like in the YAML configuration with nested keys joined by a dot, for example tls.scheme
+type Loader struct {
+	section   string
+	files     []string
+	env       bool
+	envPrefix string
+	flagSet   *flag.FlagSet
+	flagNames map[string]string
+	flags     map[string]string
+
+	values  map[string]interface{}
+	sources map[string]string
+}
+
+// NewLoader creates a new configuration loader
+func NewLoader() *Loader {
+	return &Loader{
+		flagNames: make(map[string]string),
+		flags:     make(map[string]string),
+		values:    make(map[string]interface{}),
+		sources:   make(map[string]string),
+	}
+}
+
+// WithSection reads the configuration from a top level key in files, like management in
+// a file that also holds application configuration
+func (l *Loader) WithSection(section string) *Loader {
+	l.section = section
+	return l
+}
+
+// AddFile adds a YAML, JSON or TOML file, the format is determined by the file extension
+func (l *Loader) AddFile(file string) *Loader {
+	l.files = append(l.files, file)
+	return l
+}
+
+// WithEnvironment enables overriding keys using environment variables, variable names are the
+// upper case key with dots replaced by underscores and prefixed, BACKPLANE_TLS_SCHEME for tls.scheme
+// with the default BACKPLANE prefix
+func (l *Loader) WithEnvironment(prefix string) *Loader {
+	if prefix == "" {
+		prefix = "BACKPLANE"
+	}
+
+	l.env = true
+	l.envPrefix = strings.TrimSuffix(prefix, "_")
+
+	return l
+}
+
+// BindFlags registers a flag for every key on fs, flags are named after the key with an optional
+// prefix and only flags that were set on the command line override other sources
+func (l *Loader) BindFlags(fs *flag.FlagSet, prefix string) *Loader {
+	l.flagSet = fs
+
+	for _, key := range loaderKeys {
+		name := prefix + key
+		l.flagNames[name] = key
+		fs.String(name, "", fmt.Sprintf("Override the backplane %s configuration", key))
+	}
+
+	return l
+}
+
+// SetFlag overrides a key with a value supplied on the command line, use this when
+// using a command line parser other than the flag package
+func (l *Loader) SetFlag(key string, value string) *Loader {
+	l.flags[key] = value
+	return l
+}
+
+// Load reads all the sources and produce the effective configuration
+func (l *Loader) Load() (*StandardConfiguration, error) {
+	l.values = make(map[string]interface{})
+	l.sources = make(map[string]string)
+
+	for _, file := range l.files {
+		err := l.loadFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if l.env {
+		for _, key := range loaderKeys {
+			name := l.envName(key)
+			if v, ok := os.LookupEnv(name); ok {
+				l.set(key, v, "env:"+name)
+			}
+		}
+	}
+
+	if l.flagSet != nil {
+		l.flagSet.Visit(func(f *flag.Flag) {
+			if key, ok := l.flagNames[f.Name]; ok {
+				l.set(key, f.Value.String(), "flag:"+f.Name)
+			}
+		})
+	}
+
+	for key, value := range l.flags {
+		if !isLoaderKey(key) {
+			return nil, fmt.Errorf("unknown configuration key %s", key)
+		}
+
+		l.set(key, value, "flag:"+key)
+	}
+
+	return l.build()
+}
+
+// Source reports where the effective value for key came from, one of DefaultSource,
+// file:<path>, env:<variable> or flag:<name>
+func (l *Loader) Source(key string) string {
+	src, ok := l.sources[key]
+	if !ok {
+		return DefaultSource
+	}
+
+	return src
+}
+
+// Sources reports the source of every key
+func (l *Loader) Sources() map[string]string {
+	sources := make(map[string]string)
+	for _, key := range loaderKeys {
+		sources[key] = l.Source(key)
+	}
+
+	return sources
+}
+
+// PrintEffective writes the effective configuration and the source of every key to w
+func (l *Loader) PrintEffective(w io.Writer) error {
+	width := 0
+	for _, key := range loaderKeys {
+		if len(key) > width {
+			width = len(key)
+		}
+	}
+
+	for _, key := range loaderKeys {
+		value := ""
+
+		if v, ok := l.values[key]; ok {
+			j, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+
+			value = string(j)
+
+			if key == "nats.password" {
+				value = `"********"`
+			}
+		}
+
+		_, err := fmt.Fprintf(w, "%-*s = %s (%s)\n", width, key, value, l.Source(key))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Loader) envName(key string) string {
+	return fmt.Sprintf("%s_%s", l.envPrefix, strings.ToUpper(strings.Replace(key, ".", "_", -1)))
+}
+
+func (l *Loader) set(key string, value interface{}, source string) {
+	l.values[key] = value
+	l.sources[key] = source
+}
+
+func (l *Loader) loadFile(file string) error {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %s", file, err)
+	}
+
+	data := make(map[string]interface{})
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		raw := make(map[interface{}]interface{})
+		err = yaml.Unmarshal(body, &raw)
+		if err == nil {
+			data, err = stringMap(raw)
+		}
+
+	case ".json":
+		err = json.Unmarshal(body, &data)
+
+	case ".toml":
+		err = toml.Unmarshal(body, &data)
+
+	default:
+		return fmt.Errorf("cannot determine the format of %s, supported extensions are .yaml, .yml, .json and .toml", file)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse %s: %s", file, err)
+	}
+
+	if l.section != "" {
+		section, ok := data[l.section]
+		if !ok {
+			return nil
+		}
+
+		data, ok = section.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("the %s section in %s is not a map", l.section, file)
+		}
+	}
+
+	flat := make(map[string]interface{})
+	flatten("", data, flat)
+
+	for key, value := range flat {
+		if !isLoaderKey(key) {
+			return fmt.Errorf("unknown configuration key %s in %s", key, file)
+		}
+
+		l.set(key, value, "file:"+file)
+	}
+
+	return nil
+}
+
+func (l *Loader) build() (*StandardConfiguration, error) {
+	c := &StandardConfiguration{}
+
+	var err error
+	var errs []string
+
+	str := func(key string, target *string) {
+		v, ok := l.values[key]
+		if !ok {
+			return
+		}
+
+		s, ok := v.(string)
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s from %s must be a string", key, l.Source(key)))
+			return
+		}
+
+		*target = s
+	}
+
+	list := func(key string, target *[]string) {
+		v, ok := l.values[key]
+		if !ok {
+			return
+		}
+
+		switch items := v.(type) {
+		case string:
+			for _, i := range strings.Split(items, ",") {
+				if i = strings.TrimSpace(i); i != "" {
+					*target = append(*target, i)
+				}
+			}
+
+		case []interface{}:
+			for _, i := range items {
+				s, ok := i.(string)
+				if !ok {
+					errs = append(errs, fmt.Sprintf("%s from %s must be a list of strings", key, l.Source(key)))
+					return
+				}
+
+				*target = append(*target, s)
+			}
+
+		default:
+			errs = append(errs, fmt.Sprintf("%s from %s must be a list of strings", key, l.Source(key)))
+		}
+	}
+
+	boolean := func(key string, target *bool) {
+		v, ok := l.values[key]
+		if !ok {
+			return
+		}
+
+		switch b := v.(type) {
+		case bool:
+			*target = b
+
+		case string:
+			*target, err = strconv.ParseBool(b)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s from %s must be a boolean", key, l.Source(key)))
+			}
+
+		default:
+			errs = append(errs, fmt.Sprintf("%s from %s must be a boolean", key, l.Source(key)))
+		}
+	}
+
+	str("name", &c.AppName)
+	list("brokers", &c.Brokers)
+	str("srv_domain", &c.SRVDomainName)
+	str("logfile", &c.LogFilePath)
+	str("loglevel", &c.Loglevel)
+	boolean("auth.insecure", &c.Authorization.Insecure)
+	list("auth.full", &c.Authorization.Full)
+	list("auth.read_only", &c.Authorization.RO)
+
+	for _, key := range loaderKeys {
+		if _, ok := l.values[key]; ok && strings.HasPrefix(key, "tls.") {
+			c.TLSConf = &TLSConf{}
+			break
+		}
+	}
+
+	if c.TLSConf != nil {
+		str("tls.scheme", &c.TLSConf.Scheme)
+		str("tls.identity", &c.TLSConf.Identity)
+		str("tls.ssl_dir", &c.TLSConf.SSLDir)
+		str("tls.ca", &c.TLSConf.CA)
+		str("tls.cert", &c.TLSConf.Cert)
+		str("tls.key", &c.TLSConf.Key)
+		str("tls.cache", &c.TLSConf.Cache)
+	}
+
+	for _, key := range loaderKeys {
+		if _, ok := l.values[key]; ok && strings.HasPrefix(key, "nats.") {
+			c.NATSConf = &NATSConf{}
+			break
+		}
+	}
+
+	if c.NATSConf != nil {
+		str("nats.user", &c.NATSConf.User)
+		str("nats.password", &c.NATSConf.Password)
+		str("nats.credentials", &c.NATSConf.Credentials)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid backplane configuration: %s", strings.Join(errs, ", "))
+	}
+
+	return c, nil
+}
+
+func isLoaderKey(key string) bool {
+	for _, k := range loaderKeys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flatten turns nested maps into dotted keys, lists and other values are kept as is
+func flatten(prefix string, in map[string]interface{}, out map[string]interface{}) {
+	for k, v := range in {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		if m, ok := v.(map[string]interface{}); ok {
+			flatten(key, m, out)
+			continue
+		}
+
+		out[key] = v
+	}
+}
+
+// stringMap converts the maps produced by the YAML parser to ones with string keys
+func stringMap(in map[interface{}]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+
+	for k, v := range in {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("key %v is not a string", k)
+		}
+
+		switch val := v.(type) {
+		case map[interface{}]interface{}:
+			m, err := stringMap(val)
+			if err != nil {
+				return nil, err
+			}
+
+			out[key] = m
+
+		default:
+			out[key] = val
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,158 @@
+package backplane
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/providers/agent/mcorpc"
+)
+
+// PublisherStats are counters describing the data published by the data publisher
+type PublisherStats struct {
+	// Published is how many messages were published to the broker
+	Published uint64 `json:"published"`
+
+	// Bytes is the size of the data in the published messages
+	Bytes uint64 `json:"bytes"`
+
+	// Errors is how many publish attempts failed or items were rejected by the outbox
+	Errors uint64 `json:"errors"`
+
+	// Queued is how many messages are waiting in the outbox
+	Queued int `json:"queued"`
+
+	// LastPublish is when a message was last published
+	LastPublish *time.Time `json:"last_publish,omitempty"`
+
+	// LastError is the most recent error
+	LastError string `json:"last_error,omitempty"`
+}
+
+// DestinationStats are the publisher counters for a single destination, items published
+// without a Destination are shown as agent:<target agent>
+type DestinationStats struct {
+	Destination string `json:"destination"`
+
+	PublisherStats
+}
+
+// PublisherReply is the reply from the publisher action
+type PublisherReply struct {
+	Enabled      bool               `json:"enabled"`
+	Totals       PublisherStats     `json:"totals"`
+	Destinations []DestinationStats `json:"destinations"`
+	Outbox       *OutboxStats       `json:"outbox,omitempty"`
+}
+
+// publisherMetrics tracks publisher counters for each destination
+type publisherMetrics struct {
+	mu    *sync.Mutex
+	dests map[string]*PublisherStats
+}
+
+func newPublisherMetrics() *publisherMetrics {
+	return &publisherMetrics{
+		mu:    &sync.Mutex{},
+		dests: make(map[string]*PublisherStats),
+	}
+}
+
+func (p *publisherMetrics) stats(dest string) *PublisherStats {
+	s, ok := p.dests[dest]
+	if !ok {
+		s = &PublisherStats{}
+		p.dests[dest] = s
+	}
+
+	return s
+}
+
+func (p *publisherMetrics) published(item *DataItem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now().UTC()
+	s := p.stats(item.destination())
+	s.Published++
+	s.Bytes += uint64(len(item.Data))
+	s.LastPublish = &now
+}
+
+func (p *publisherMetrics) failed(item *DataItem, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.stats(item.destination())
+	s.Errors++
+	s.LastError = err.Error()
+}
+
+// PublisherStats reports the data publisher counters for all destinations combined
+func (m *Management) PublisherStats() PublisherStats {
+	totals, _ := m.publisherStats()
+
+	return totals
+}
+
+// DestinationStats reports the data publisher counters for every destination sorted by name
+func (m *Management) DestinationStats() []DestinationStats {
+	_, dests := m.publisherStats()
+
+	return dests
+}
+
+func (m *Management) publisherStats() (PublisherStats, []DestinationStats) {
+	depths := map[string]int{}
+	if m.queue != nil {
+		depths = m.queue.depths()
+	}
+
+	m.metrics.mu.Lock()
+	defer m.metrics.mu.Unlock()
+
+	totals := PublisherStats{}
+	dests := []DestinationStats{}
+
+	for dest := range depths {
+		m.metrics.stats(dest)
+	}
+
+	for dest, s := range m.metrics.dests {
+		d := DestinationStats{Destination: dest, PublisherStats: *s}
+		d.Queued = depths[dest]
+		dests = append(dests, d)
+
+		totals.Published += d.Published
+		totals.Bytes += d.Bytes
+		totals.Errors += d.Errors
+		totals.Queued += d.Queued
+
+		if d.LastPublish != nil && (totals.LastPublish == nil || d.LastPublish.After(*totals.LastPublish)) {
+			totals.LastPublish = d.LastPublish
+		}
+	}
+
+	sort.Slice(dests, func(i, j int) bool { return dests[i].Destination < dests[j].Destination })
+
+	return totals, dests
+}
+
+func (m *Management) publisherAction(ctx context.Context, req *mcorpc.Request, reply *mcorpc.Reply, agent *mcorpc.Agent, conn inter.ConnectorInfo) {
+	totals, dests := m.publisherStats()
+
+	r := &PublisherReply{
+		Enabled:      m.cfg.publishdata,
+		Totals:       totals,
+		Destinations: dests,
+	}
+
+	if m.cfg.publishdata {
+		stats := m.OutboxStats()
+		r.Outbox = &stats
+	}
+
+	reply.Data = r
+}
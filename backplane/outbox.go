@@ -0,0 +1,375 @@
+package backplane
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy determines what Publish does when the outbox is full
+type OverflowPolicy int
+
+const (
+	// DropNewest rejects the item being published
+	DropNewest OverflowPolicy = iota
+
+	// DropOldest discards the oldest item in the outbox to make room, the item being published is
+	// never discarded so with an outbox size of 1 new items are rejected while it is published
+	DropOldest
+
+	// BlockWithTimeout waits for room in the outbox and rejects the item after a timeout
+	BlockWithTimeout
+
+	// SpillToDisk writes items to a spool directory when the outbox is full or the broker
+	// is not reachable, spooled items are published in order once there is a connection
+	SpillToDisk
+)
+
+// String returns the name of the overflow policy
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case BlockWithTimeout:
+		return "block"
+	case SpillToDisk:
+		return "spill"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	// ErrOutboxFull is returned by Publish when the item could not be queued due to the outbox being full
+	ErrOutboxFull = errors.New("data outbox is full")
+
+	// ErrPublisherDisabled is returned by Publish when the data publisher is not enabled
+	ErrPublisherDisabled = errors.New("the data publisher is not enabled")
+)
+
+// OutboxStats are counters describing the data outbox
+type OutboxStats struct {
+	// Policy is the overflow policy in use
+	Policy string `json:"policy"`
+
+	// Size is how many items the outbox holds in memory
+	Size int `json:"size"`
+
+	// Queued is how many items are currently waiting to be published, including spilled ones
+	Queued int `json:"queued"`
+
+	// Spilled is how many of the queued items are stored on disk
+	Spilled int `json:"spilled"`
+
+	// SpoolBytes is the size of the spool directory
+	SpoolBytes int64 `json:"spool_bytes"`
+
+	// Dropped is how many items were discarded or rejected due to the outbox being full
+	// or that were removed from the spool due to its size or age limits
+	Dropped uint64 `json:"dropped"`
+}
+
+// OutboxSize sets how many items the data outbox holds before the overflow policy applies, 1000 is default
+func OutboxSize(n int) Option {
+	return func(c *Config) {
+		c.outboxSize = n
+	}
+}
+
+// OutboxOverflow sets what happens when publishing to a full data outbox, DropNewest is default
+func OutboxOverflow(p OverflowPolicy) Option {
+	return func(c *Config) {
+		c.outboxPolicy = p
+	}
+}
+
+// OutboxTimeout is how long Publish waits for room in the outbox using the BlockWithTimeout policy, 5 seconds is default
+func OutboxTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.outboxTimeout = d
+	}
+}
+
+// OutboxSpill sets the SpillToDisk overflow policy writing items that do not fit in the outbox,
+// or that are published while the broker is not reachable, to a spool in dir
+func OutboxSpill(dir string) Option {
+	return func(c *Config) {
+		c.outboxPolicy = SpillToDisk
+		c.outboxDir = dir
+	}
+}
+
+// SpoolLimits limits the spool used by OutboxSpill to maxBytes on disk, discarding the oldest
+// items when it grows larger, and discards items older than maxAge rather than publishing
+// them, 0 disables a limit, defaults are 100MiB and no age limit
+func SpoolLimits(maxBytes int64, maxAge time.Duration) Option {
+	return func(c *Config) {
+		c.spoolMaxBytes = maxBytes
+		c.spoolMaxAge = maxAge
+	}
+}
+
+// Publish queues item for publishing by the data publisher, when the outbox is full the
+// configured OverflowPolicy applies and ErrOutboxFull is returned for rejected items
+func (m *Management) Publish(item *DataItem) error {
+	if !m.cfg.publishdata {
+		return ErrPublisherDisabled
+	}
+
+	if m.batcher != nil {
+		return m.batcher.add(item)
+	}
+
+	return m.enqueue(item)
+}
+
+// enqueue adds item to the outbox recording items that were rejected
+func (m *Management) enqueue(item *DataItem) error {
+	err := m.queue.push(item)
+	if err != nil {
+		m.metrics.failed(item, err)
+	}
+
+	return err
+}
+
+// OutboxStats reports counters about the data outbox
+func (m *Management) OutboxStats() OutboxStats {
+	if m.queue == nil {
+		return OutboxStats{}
+	}
+
+	return m.queue.stats()
+}
+
+// dataQueue is a bounded FIFO of items waiting to be published, items stay in the
+// queue until the publisher acknowledges them so nothing is lost while disconnected
+type dataQueue struct {
+	size      int
+	policy    OverflowPolicy
+	timeout   time.Duration
+	spill     *spool
+	connected func() bool // called without holding mu, must not block
+
+	mu      *sync.Mutex
+	items   []*DataItem
+	dropped uint64
+
+	// inflight is the item returned by peek that the publisher is publishing, it is never dropped
+	inflight *DataItem
+
+	// ready receives a value when items were added and space when items were removed
+	ready chan struct{}
+	space chan struct{}
+}
+
+func newDataQueue(c *Config, connected func() bool, log func(format string, args ...interface{})) (*dataQueue, error) {
+	q := &dataQueue{
+		size:      c.outboxSize,
+		policy:    c.outboxPolicy,
+		timeout:   c.outboxTimeout,
+		connected: connected,
+		mu:        &sync.Mutex{},
+		ready:     make(chan struct{}, 1),
+		space:     make(chan struct{}, 1),
+	}
+
+	if q.policy == SpillToDisk {
+		var err error
+		q.spill, err = newSpool(c.outboxDir, c.spoolMaxBytes, c.spoolMaxAge, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+func (q *dataQueue) push(item *DataItem) error {
+	var timeout <-chan time.Time
+
+	for {
+		// connectivity is determined before locking the queue, connected must never be
+		// called with q.mu held
+		connected := q.connected()
+
+		q.mu.Lock()
+
+		// once items are spilled new items go to disk too so they are published in order,
+		// while disconnected items go straight to disk so they survive restarts
+		if q.spill != nil && (q.spill.len() > 0 || !connected) {
+			err := q.spill.push(item)
+			q.mu.Unlock()
+			q.signal(q.ready)
+
+			return err
+		}
+
+		if len(q.items) < q.size {
+			q.items = append(q.items, item)
+			q.mu.Unlock()
+			q.signal(q.ready)
+
+			return nil
+		}
+
+		switch q.policy {
+		case DropOldest:
+			// the item being published can not be dropped, the one after it is the oldest that can
+			oldest := 0
+			if q.items[0] == q.inflight {
+				oldest = 1
+			}
+
+			// only the item being published is queued so there is nothing older to drop
+			if oldest >= len(q.items) {
+				q.dropped++
+				q.mu.Unlock()
+
+				return ErrOutboxFull
+			}
+
+			q.items = append(q.items[:oldest], q.items[oldest+1:]...)
+			q.items = append(q.items, item)
+			q.dropped++
+			q.mu.Unlock()
+
+			return nil
+
+		case SpillToDisk:
+			err := q.spill.push(item)
+			q.mu.Unlock()
+			q.signal(q.ready)
+
+			return err
+
+		case BlockWithTimeout:
+			q.mu.Unlock()
+
+			if timeout == nil {
+				timeout = time.After(q.timeout)
+			}
+
+			select {
+			case <-q.space:
+				continue
+			case <-timeout:
+				q.mu.Lock()
+				q.dropped++
+				q.mu.Unlock()
+
+				return ErrOutboxFull
+			}
+
+		default:
+			q.dropped++
+			q.mu.Unlock()
+
+			return ErrOutboxFull
+		}
+	}
+}
+
+// peek returns the oldest item without removing it, nil when the queue is empty
+func (q *dataQueue) peek() (*DataItem, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) > 0 {
+		q.inflight = q.items[0]
+		return q.items[0], nil
+	}
+
+	if q.spill == nil {
+		return nil, nil
+	}
+
+	return q.spill.peek()
+}
+
+// ack removes item after it was published, items that were dropped since
+// they were returned by peek are ignored
+func (q *dataQueue) ack(item *DataItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.inflight == item {
+		q.inflight = nil
+	}
+
+	if len(q.items) > 0 {
+		if q.items[0] != item {
+			return nil
+		}
+
+		q.items[0] = nil
+		q.items = q.items[1:]
+		q.signal(q.space)
+
+		return nil
+	}
+
+	if q.spill != nil && q.spill.head == item {
+		return q.spill.ack()
+	}
+
+	return nil
+}
+
+func (q *dataQueue) close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.spill == nil {
+		return nil
+	}
+
+	return q.spill.close()
+}
+
+// depths is the number of queued items for each destination
+func (q *dataQueue) depths() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depths := make(map[string]int)
+	for _, item := range q.items {
+		depths[item.destination()]++
+	}
+
+	if q.spill != nil {
+		q.spill.depths(depths)
+	}
+
+	return depths
+}
+
+func (q *dataQueue) stats() OutboxStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := OutboxStats{
+		Policy:  q.policy.String(),
+		Size:    q.size,
+		Queued:  len(q.items),
+		Dropped: q.dropped,
+	}
+
+	if q.spill != nil {
+		s.Spilled = q.spill.len()
+		s.Queued += s.Spilled
+		s.SpoolBytes = q.spill.bytes
+		s.Dropped += q.spill.dropped
+	}
+
+	return s
+}
+
+func (q *dataQueue) signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,149 @@
+package backplane
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDataQueuePushChecksConnectivityUnlocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatalf("could not create spool directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &Config{outboxSize: 10, outboxPolicy: SpillToDisk, outboxDir: dir}
+
+	var q *dataQueue
+	q, err = newDataQueue(c, func() bool {
+		// inspecting the queue here would deadlock if push held its lock
+		q.stats()
+		return true
+	}, t.Logf)
+	if err != nil {
+		t.Fatalf("could not create queue: %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- q.push(&DataItem{Data: []byte("hello")}) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("could not push item: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("push did not complete, connectivity was checked while holding the queue lock")
+	}
+
+	if q.stats().Queued != 1 {
+		t.Fatalf("expected 1 queued item got %d", q.stats().Queued)
+	}
+
+	err = q.close()
+	if err != nil {
+		t.Fatalf("could not close queue: %s", err)
+	}
+}
+
+func TestIsConnectedFollowsState(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	m := &Management{
+		cfg:     &Config{},
+		log:     logrus.NewEntry(logger),
+		stateMu: &sync.Mutex{},
+		state:   Connecting,
+	}
+
+	if m.isConnected() {
+		t.Fatalf("expected not to be connected while connecting")
+	}
+
+	m.setState(Connected)
+	if !m.isConnected() {
+		t.Fatalf("expected to be connected")
+	}
+
+	m.setState(Reconnecting)
+	if m.isConnected() {
+		t.Fatalf("expected not to be connected while reconnecting")
+	}
+}
+
+func TestDataQueueDropOldestSkipsInflight(t *testing.T) {
+	c := &Config{outboxSize: 2, outboxPolicy: DropOldest}
+
+	q, err := newDataQueue(c, func() bool { return true }, t.Logf)
+	if err != nil {
+		t.Fatalf("could not create queue: %s", err)
+	}
+
+	one := &DataItem{Data: []byte("1")}
+	two := &DataItem{Data: []byte("2")}
+	three := &DataItem{Data: []byte("3")}
+
+	q.push(one)
+	q.push(two)
+
+	head, _ := q.peek()
+	if head != one {
+		t.Fatalf("expected the first item to be published first")
+	}
+
+	err = q.push(three)
+	if err != nil {
+		t.Fatalf("could not push item: %s", err)
+	}
+
+	if len(q.items) != 2 || q.items[0] != one || q.items[1] != three {
+		t.Fatalf("expected the item after the one being published to be dropped")
+	}
+
+	q.ack(one)
+
+	next, _ := q.peek()
+	if next != three {
+		t.Fatalf("expected the newest item to be published next")
+	}
+
+	if q.stats().Dropped != 1 {
+		t.Fatalf("expected 1 dropped item got %d", q.stats().Dropped)
+	}
+}
+
+func TestDataQueueDropOldestSingleInflight(t *testing.T) {
+	c := &Config{outboxSize: 1, outboxPolicy: DropOldest}
+
+	q, err := newDataQueue(c, func() bool { return true }, t.Logf)
+	if err != nil {
+		t.Fatalf("could not create queue: %s", err)
+	}
+
+	one := &DataItem{Data: []byte("1")}
+	q.push(one)
+	q.peek()
+
+	err = q.push(&DataItem{Data: []byte("2")})
+	if err != ErrOutboxFull {
+		t.Fatalf("expected ErrOutboxFull got %v", err)
+	}
+
+	head, _ := q.peek()
+	if head != one {
+		t.Fatalf("expected the item being published to be kept")
+	}
+
+	q.ack(one)
+
+	err = q.push(&DataItem{Data: []byte("3")})
+	if err != nil {
+		t.Fatalf("could not push item once the previous one was published: %s", err)
+	}
+}
@@ -0,0 +1,292 @@
+package backplane
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/choria-io/go-choria/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// Reconfigure applies a new configuration to the running backplane
+//
+// Authorization rules and the log level are applied immediately, changes to the middleware
+// hosts, TLS or NATS authentication settings restarts the embedded Choria Server without affecting the host
+// application. When the restarted server cannot connect within the connection timeout the previous
+// configuration is restored and an error is returned. The name of the backplane can not be changed at runtime.
+//
+// When the backplane was started using BackgroundConnect the restarted server connects in the
+// background, the new configuration is kept even when it cannot connect and ConnectionStatus
+// reports the progress of the connection attempts.
+func (m *Management) Reconfigure(conf ConfigProvider) error {
+	m.reconfMu.Lock()
+	defer m.reconfMu.Unlock()
+
+	if m.ctx.Err() != nil {
+		return fmt.Errorf("the backplane has been shut down")
+	}
+
+	if conf.Name() != m.cfg.provider.Name() {
+		return fmt.Errorf("the application name cannot be changed from %s to %s", m.cfg.provider.Name(), conf.Name())
+	}
+
+	ncfg, err := newConfig(m.cfg.name, conf, m.cfg.opts...)
+	if err != nil {
+		return fmt.Errorf("invalid backplane configuration: %s", err)
+	}
+
+	m.mu.Lock()
+	restart := !reflect.DeepEqual(m.cfg.brokers, ncfg.brokers) || m.cfg.srvDomain != ncfg.srvDomain || !reflect.DeepEqual(m.cfg.tls, ncfg.tls) || m.cfg.tlsState != ncfg.tlsState || !reflect.DeepEqual(m.cfg.nats, ncfg.nats)
+	m.mu.Unlock()
+
+	if restart {
+		err = m.restart(ncfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.cfg.provider = conf
+	m.cfg.auth = ncfg.auth
+	m.cfg.loglevel = ncfg.loglevel
+	m.mu.Unlock()
+
+	m.applyLogLevel(ncfg.loglevel)
+
+	m.log.Infof("Applied new authorization and log level configuration")
+
+	return nil
+}
+
+// restart replaces the Choria Server with one using the connection settings of ncfg, when the
+// new server cannot be started the previous settings are restored and the server restarted
+func (m *Management) restart(ncfg *Config) error {
+	m.log.Warnf("Restarting the Choria Server to apply changed middleware or TLS configuration")
+
+	if m.State() == Connected {
+		m.setState(Reconnecting)
+	}
+
+	m.stopConnectLoop()
+	m.stopInstance()
+
+	m.mu.Lock()
+	previous := *m.cfg
+	ncfg.ccfg.FactSourceFile = m.cfg.ccfg.FactSourceFile
+	m.mu.Unlock()
+
+	// creating the framework changes the global protocol security
+	secure := protocol.Secure
+
+	err := ncfg.setupFramework(m.log.Logger)
+	if err == nil {
+		m.applyConnectionConfig(ncfg)
+
+		if m.cfg.backgroundConnect {
+			m.startBackgroundConnect()
+			return nil
+		}
+
+		// without a timeout a broker that cannot be reached would block here forever
+		err = m.connect(m.ctx, m.cfg.connectTimeout)
+		if err == nil {
+			return nil
+		}
+	}
+
+	m.log.Errorf("Could not restart the Choria Server using the new configuration, restoring the previous configuration: %s", err)
+
+	protocol.Secure = secure
+	m.applyConnectionConfig(&previous)
+
+	if m.cfg.backgroundConnect {
+		m.startBackgroundConnect()
+	} else {
+		rerr := m.connect(m.ctx, 0)
+		if rerr != nil {
+			m.log.Errorf("Could not restart the Choria Server using the previous configuration: %s", rerr)
+		}
+	}
+
+	return fmt.Errorf("could not restart the backplane: %s", err)
+}
+
+// applyConnectionConfig switches to the connection settings and framework of c, the framework
+// shares the logger of the backplane so only the identity is taken from it
+func (m *Management) applyConnectionConfig(c *Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cfg.brokers = c.brokers
+	m.cfg.srvDomain = c.srvDomain
+	m.cfg.tls = c.tls
+	m.cfg.tlsState = c.tlsState
+	m.cfg.nats = c.nats
+	m.cfg.ccfg = c.ccfg
+	m.cfg.fw = c.fw
+	m.identity = c.ccfg.Identity
+}
+
+// currentIdentity is the identity of the running server, it changes when a restart applies a new TLS identity
+func (m *Management) currentIdentity() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.identity
+}
+
+// applyLogLevel sets the log level the same way Choria does at startup, unsupported levels log at warn level
+func (m *Management) applyLogLevel(level string) {
+	lvl, ok := logLevels[level]
+	if !ok {
+		lvl = logrus.WarnLevel
+	}
+
+	m.log.Logger.SetLevel(lvl)
+}
+
+// tlsFingerprint is a digest of the contents of the certificates and keys used by tls so that
+// files replaced in place, for example when certificates are rotated, can be detected
+func tlsFingerprint(tls *TLSConf) string {
+	if tls == nil {
+		return ""
+	}
+
+	h := sha256.New()
+
+	add := func(file string) {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return
+		}
+
+		fmt.Fprintf(h, "%s:%d:", file, len(body))
+		h.Write(body)
+	}
+
+	switch tls.Scheme {
+	case "puppet":
+		if tls.SSLDir == "" {
+			break
+		}
+
+		filepath.Walk(tls.SSLDir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				add(path)
+			}
+
+			return nil
+		})
+
+	default:
+		add(tls.CA)
+		add(tls.Cert)
+		add(tls.Key)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WatchConfigurationFile polls a YAML file holding a StandardConfiguration every interval
+// and reconfigures the backplane whenever the file contents or the TLS certificates change
+func (m *Management) WatchConfigurationFile(ctx context.Context, wg *sync.WaitGroup, file string, interval time.Duration) error {
+	last, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not read configuration file %s: %s", file, err)
+	}
+
+	wg.Add(1)
+	go m.configWatcher(ctx, wg, file, interval, last)
+
+	return nil
+}
+
+func (m *Management) configWatcher(ctx context.Context, wg *sync.WaitGroup, file string, interval time.Duration, last []byte) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastMod := time.Time{}
+	if stat, err := os.Stat(file); err == nil {
+		lastMod = stat.ModTime()
+	}
+
+	m.log.Infof("Watching %s for configuration changes every %s", file, interval)
+
+	for {
+		select {
+		case <-ticker.C:
+			stat, err := os.Stat(file)
+			if err != nil {
+				m.log.Errorf("Could not check configuration file %s: %s", file, err)
+				continue
+			}
+
+			if stat.ModTime().Equal(lastMod) {
+				m.reconfigureRotatedTLS()
+				continue
+			}
+
+			lastMod = stat.ModTime()
+
+			body, err := ioutil.ReadFile(file)
+			if err != nil {
+				m.log.Errorf("Could not read configuration file %s: %s", file, err)
+				continue
+			}
+
+			if bytes.Equal(body, last) {
+				continue
+			}
+
+			last = body
+
+			conf, err := parseStandardConfiguration(body)
+			if err != nil {
+				m.log.Errorf("Could not parse configuration file %s: %s", file, err)
+				continue
+			}
+
+			m.log.Infof("Reconfiguring the backplane after changes to %s", file)
+
+			err = m.Reconfigure(conf)
+			if err != nil {
+				m.log.Errorf("Could not reconfigure the backplane using %s: %s", file, err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reconfigureRotatedTLS restarts the backplane when the certificates or keys it uses were replaced
+func (m *Management) reconfigureRotatedTLS() {
+	m.mu.Lock()
+	tls := m.cfg.tls
+	state := m.cfg.tlsState
+	provider := m.cfg.provider
+	m.mu.Unlock()
+
+	if tls == nil || tlsFingerprint(tls) == state {
+		return
+	}
+
+	m.log.Infof("Reconfiguring the backplane after changes to the TLS certificates")
+
+	err := m.Reconfigure(provider)
+	if err != nil {
+		m.log.Errorf("Could not reconfigure the backplane after changes to the TLS certificates: %s", err)
+	}
+}
@@ -2,11 +2,26 @@ package backplane
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/protocol"
 	"github.com/choria-io/go-choria/server/data"
 )
 
+// publishRetryInterval is how long to wait before retrying a failed data publish
+var publishRetryInterval = time.Second
+
+// publishMaxAttempts is how often publishing an item is attempted before it is discarded,
+// attempts made while not connected are not counted
+var publishMaxAttempts = 5
+
+var errNotConnected = errors.New("not connected to the network")
+
 // DataItem contains a single data message
 type DataItem struct {
 	// Data is the raw data to publish
@@ -14,33 +29,166 @@ type DataItem struct {
 
 	// Destination let you set custom NATS targets, when this is not set
 	// the TargetAgent will be used to create a normal agent target
-	Destination string
+	Destination string `json:",omitempty"`
 
 	// TargetAgent lets you pick where to send the data as a request
-	TargetAgent string
+	TargetAgent string `json:",omitempty"`
+}
+
+// destination identifies where the item is published to in publisher statistics
+func (d *DataItem) destination() string {
+	if d.Destination != "" {
+		return d.Destination
+	}
+
+	if d.TargetAgent != "" {
+		return "agent:" + d.TargetAgent
+	}
+
+	return "agent:registration"
 }
 
-// DataOutbox returns the channel to use for publishing data to the network from the backplane
+// DataOutbox returns the channel to use for publishing data to the network from the backplane,
+// sending to it blocks while the backplane is not connected, use Publish to avoid this
 func (m *Management) DataOutbox() chan *DataItem {
 	return m.outbox
 }
 
 // StartRegistration implements registration.RegistrationDataProvider
+//
+// Deprecated: the backplane publishes the data in its outbox itself, registering it as a
+// registration provider would deliver the data twice so this does not produce any data
 func (m *Management) StartRegistration(ctx context.Context, wg *sync.WaitGroup, interval int, output chan *data.RegistrationItem) {
+	defer wg.Done()
+
+	m.log.Warnf("The backplane publishes its data itself, it does not provide data to registration providers")
+}
+
+func (m *Management) startDataPublisher(ctx context.Context, wg *sync.WaitGroup) error {
+	wg.Add(1)
+	go m.dataPublisher(ctx, wg)
+
+	return nil
+}
+
+// dataPublisher publishes items from the outbox, items are only removed once the broker
+// accepted them and are retried until then so data survives broker outages.  Items that
+// fail to publish while connected are discarded after publishMaxAttempts attempts so that
+// they do not block the items behind them
+func (m *Management) dataPublisher(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	var current *DataItem
+	attempts := 0
+
 	for {
+		item, err := m.nextItem(ctx)
+		if err != nil {
+			m.log.Errorf("Could not retrieve data from the outbox: %s", err)
+			continue
+		}
+
+		if item == nil {
+			return
+		}
+
+		if item != current {
+			current = item
+			attempts = 0
+		}
+
+		err = m.publishItem(item)
+		if err != nil {
+			// waiting for a connection is not an error, the item is published once connected
+			if err != errNotConnected {
+				attempts++
+
+				if attempts >= publishMaxAttempts {
+					m.log.Errorf("Discarding data for %s after %d failed attempts to publish it: %s", item.destination(), attempts, err)
+					m.metrics.failed(item, err)
+					m.ackItem(item)
+					continue
+				}
+			}
+
+			m.log.Warnf("Could not publish data, retrying in %s: %s", publishRetryInterval, err)
+
+			select {
+			case <-time.After(publishRetryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		m.metrics.published(item)
+		m.ackItem(item)
+	}
+}
+
+// nextItem waits for the oldest item in the outbox, items sent to the DataOutbox channel
+// are added to the outbox, nil is returned when ctx is done
+func (m *Management) nextItem(ctx context.Context) (*DataItem, error) {
+	for {
+		item, err := m.queue.peek()
+		if err != nil || item != nil {
+			return item, err
+		}
+
 		select {
-		case msg := <-m.outbox:
-			output <- &data.RegistrationItem{
-				Data:        msg.Data,
-				Destination: msg.Destination,
-				TargetAgent: msg.TargetAgent,
+		case <-m.queue.ready:
+		case item = <-m.outbox:
+			err = m.Publish(item)
+			if err != nil {
+				m.log.Warnf("Could not add data from the outbox channel: %s", err)
 			}
 		case <-ctx.Done():
-			return
+			return nil, nil
 		}
 	}
 }
 
-func (m *Management) startDataPublisher(ctx context.Context, wg *sync.WaitGroup) error {
-	return m.cserver.RegisterRegistrationProvider(ctx, wg, m)
+func (m *Management) ackItem(item *DataItem) {
+	err := m.queue.ack(item)
+	if err != nil {
+		m.log.Errorf("Could not remove published data from the outbox: %s", err)
+	}
+}
+
+// publishItem publishes item the same way Choria publishes registration data
+func (m *Management) publishItem(item *DataItem) error {
+	conn, msg, err := m.newDataMessage(item)
+	if err != nil {
+		return err
+	}
+
+	return conn.Publish(msg)
+}
+
+// newDataMessage creates a message for item and returns it with the connector to publish it
+func (m *Management) newDataMessage(item *DataItem) (inter.Connector, inter.Message, error) {
+	m.mu.Lock()
+	srv := m.cserver
+	fw := m.cfg.fw
+	m.mu.Unlock()
+
+	if srv == nil || srv.Connector() == nil || !srv.Connector().IsConnected() {
+		return nil, nil, errNotConnected
+	}
+
+	target := item.TargetAgent
+	if target == "" {
+		target = "registration"
+	}
+
+	msg, err := choria.NewMessage(string(item.Data), target, m.cfg.appname, inter.RequestMessageType, nil, fw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create message: %s", err)
+	}
+
+	msg.SetProtocolVersion(protocol.RequestV1)
+	msg.SetReplyTo("dev.null")
+	msg.SetCustomTarget(item.Destination)
+
+	return srv.Connector(), msg, nil
 }
@@ -9,20 +9,28 @@ import (
 	"github.com/choria-io/go-choria/server"
 )
 
+var registerProvider = &sync.Once{}
+
 func (m *Management) startServer(ctx context.Context, wg *sync.WaitGroup) (err error) {
-	m.cserver, err = server.NewInstance(m.cfg.fw)
+	srv, err := server.NewInstance(m.cfg.fw)
 	if err != nil {
 		return fmt.Errorf("could not initialize the backplane Choria Server: %s", err)
 	}
 
-	m.cserver.DenyAgent("rpcutil")
-	m.cserver.DenyAgent("choria_util")
-	m.cserver.SetComponent("backplane")
+	srv.DenyAgent("rpcutil")
+	srv.DenyAgent("choria_util")
+	srv.SetComponent("backplane")
+
+	registerProvider.Do(func() {
+		server.RegisterAdditionalAgentProvider(&gorpc.Provider{})
+	})
 
-	server.RegisterAdditionalAgentProvider(&gorpc.Provider{})
+	m.mu.Lock()
+	m.cserver = srv
+	m.mu.Unlock()
 
 	wg.Add(1)
-	err = m.cserver.Run(ctx, wg)
+	err = srv.Run(ctx, wg)
 	if err != nil {
 		return
 	}
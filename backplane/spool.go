@@ -0,0 +1,556 @@
+package backplane
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// spoolSegmentSize is the size at which a new segment file is started
+	spoolSegmentSize = 4 * 1024 * 1024
+
+	// spoolHeaderSize is the length and checksum stored ahead of every record
+	spoolHeaderSize = 8
+
+	// spoolMaxRecord is the largest record that can be stored
+	spoolMaxRecord = 64 * 1024 * 1024
+
+	spoolSegmentSuffix = ".seg"
+	spoolCursorFile    = "cursor.json"
+)
+
+var errSpoolCorrupt = errors.New("corrupt spool record")
+
+// spoolRecord is a single item stored in the spool
+type spoolRecord struct {
+	Time time.Time `json:"time"`
+	Item *DataItem `json:"item"`
+}
+
+// spoolCursor records how far the oldest segment has been published
+type spoolCursor struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// spoolSegment is an append only file holding records, count is the number of
+// records in the segment that are still to be published and dests how many of
+// those are for each destination
+type spoolSegment struct {
+	seq   uint64
+	size  int64
+	count int
+	dests map[string]int
+}
+
+// spool is a FIFO of items stored in append only segment files in a directory
+//
+// Every record is written with its length and a CRC32 checksum and synced to disk,
+// on start up a torn or corrupt record at the end of a segment is truncated away.
+// The position of the oldest unpublished record is kept in a cursor file that is
+// updated after every record was published, a crash between publishing and saving
+// the cursor will result in the record being published again.  A cursor that can
+// not be read is ignored and the oldest segment is published again from its start,
+// publishing records twice is preferred over losing them or failing to start.
+//
+// Segments are removed once all their records were published or, when the spool
+// grows beyond maxBytes, the oldest segments are discarded to make room.
+type spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	segSize  int64
+	log      func(format string, args ...interface{})
+
+	segments []*spoolSegment
+	writer   *os.File
+	offset   int64
+	count    int
+	bytes    int64
+	dropped  uint64
+
+	head     *DataItem
+	headSize int64
+}
+
+func newSpool(dir string, maxBytes int64, maxAge time.Duration, log func(format string, args ...interface{})) (*spool, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s: %s", dir, err)
+	}
+
+	s := &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		segSize:  spoolSegmentSize,
+		log:      log,
+	}
+
+	// segments should be small enough that dropping one frees a fraction of the spool
+	if s.maxBytes > 0 && s.maxBytes/4 < s.segSize {
+		s.segSize = s.maxBytes / 4
+	}
+
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load finds the segments in the spool directory and positions the reader using the cursor
+func (s *spool) load() error {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %s", s.dir, err)
+	}
+
+	cursor := spoolCursor{}
+	cj, err := ioutil.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if err == nil {
+		err = json.Unmarshal(cj, &cursor)
+		if err != nil {
+			s.log("Could not decode the spool cursor in %s, publishing the oldest spooled data again: %s", s.dir, err)
+			cursor = spoolCursor{}
+		}
+	} else if !os.IsNotExist(err) {
+		s.log("Could not read the spool cursor in %s, publishing the oldest spooled data again: %s", s.dir, err)
+	}
+
+	next := cursor.Segment
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), spoolSegmentSuffix) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if seq >= next {
+			next = seq + 1
+		}
+
+		// segments before the cursor were fully published
+		if seq < cursor.Segment {
+			os.Remove(s.path(seq))
+			continue
+		}
+
+		s.segments = append(s.segments, newSpoolSegment(seq))
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	for i, seg := range s.segments {
+		start := int64(0)
+		if i == 0 && seg.seq == cursor.Segment {
+			start = cursor.Offset
+		}
+
+		err = s.scan(seg, start)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			s.offset = start
+			if s.offset > seg.size {
+				s.offset = seg.size
+			}
+		}
+
+		s.count += seg.count
+		s.bytes += seg.size
+	}
+
+	if len(s.segments) == 0 {
+		return s.rotate(next)
+	}
+
+	return s.openWriter()
+}
+
+// scan validates the records in seg truncating it at the first invalid record and
+// counts the records found from start onward
+func (s *spool) scan(seg *spoolSegment, start int64) error {
+	f, err := os.Open(s.path(seg.seq))
+	if err != nil {
+		return fmt.Errorf("could not open spool segment: %s", err)
+	}
+
+	var pos int64
+	for {
+		rec, n, err := readSpoolRecord(f)
+		if err != nil {
+			break
+		}
+
+		if pos >= start {
+			seg.count++
+			seg.dests[rec.Item.destination()]++
+		}
+
+		pos += n
+	}
+	f.Close()
+
+	seg.size = pos
+
+	err = os.Truncate(s.path(seg.seq), pos)
+	if err != nil {
+		return fmt.Errorf("could not truncate spool segment: %s", err)
+	}
+
+	return nil
+}
+
+func newSpoolSegment(seq uint64) *spoolSegment {
+	return &spoolSegment{seq: seq, dests: make(map[string]int)}
+}
+
+func (s *spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentSuffix))
+}
+
+func (s *spool) openWriter() error {
+	if s.writer != nil {
+		s.writer.Close()
+	}
+
+	last := s.segments[len(s.segments)-1]
+
+	var err error
+	s.writer, err = os.OpenFile(s.path(last.seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open spool segment: %s", err)
+	}
+
+	// a segment that was just created only survives a crash once the directory is synced
+	return syncDir(s.dir)
+}
+
+// rotate starts a new segment with sequence seq and writes new records to it
+func (s *spool) rotate(seq uint64) error {
+	s.segments = append(s.segments, newSpoolSegment(seq))
+
+	return s.openWriter()
+}
+
+func (s *spool) len() int {
+	return s.count
+}
+
+// depths adds the number of spooled records for each destination to depths
+func (s *spool) depths(depths map[string]int) {
+	for _, seg := range s.segments {
+		for dest, count := range seg.dests {
+			depths[dest] += count
+		}
+	}
+}
+
+func (s *spool) push(item *DataItem) error {
+	rec, err := json.Marshal(spoolRecord{Time: time.Now().UTC(), Item: item})
+	if err != nil {
+		return fmt.Errorf("could not encode item: %s", err)
+	}
+
+	if len(rec) > spoolMaxRecord {
+		return fmt.Errorf("item of %d bytes is too large to spool", len(rec))
+	}
+
+	size := int64(len(rec) + spoolHeaderSize)
+
+	if s.maxBytes > 0 {
+		if size > s.maxBytes {
+			return fmt.Errorf("item of %d bytes exceeds the spool size limit of %d bytes", size, s.maxBytes)
+		}
+
+		for s.bytes+size > s.maxBytes && s.count > 0 {
+			err = s.dropOldest()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	last := s.segments[len(s.segments)-1]
+	if last.size > 0 && last.size+size > s.segSize {
+		err = s.rotate(last.seq + 1)
+		if err != nil {
+			return err
+		}
+		last = s.segments[len(s.segments)-1]
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(rec)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(rec))
+	copy(buf[spoolHeaderSize:], rec)
+
+	_, err = s.writer.Write(buf)
+	if err == nil {
+		err = s.writer.Sync()
+	}
+	if err != nil {
+		// remove any partial write so later records are not appended after it
+		os.Truncate(s.path(last.seq), last.size)
+		return fmt.Errorf("could not store item: %s", err)
+	}
+
+	last.size += size
+	last.count++
+	last.dests[item.destination()]++
+	s.count++
+	s.bytes += size
+
+	return nil
+}
+
+// peek returns the oldest item without removing it, items older than the age
+// limit are discarded, nil is returned when the spool is empty
+func (s *spool) peek() (*DataItem, error) {
+	for s.head == nil {
+		if s.count == 0 {
+			return nil, nil
+		}
+
+		seg := s.segments[0]
+		if seg.count == 0 {
+			err := s.removeOldest()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		rec, n, err := s.read(seg)
+		if err != nil {
+			// skip the rest of the unreadable segment
+			s.dropped += uint64(seg.count)
+			s.count -= seg.count
+			seg.count = 0
+			seg.dests = nil
+
+			rerr := s.removeOldest()
+			if rerr != nil {
+				return nil, rerr
+			}
+
+			return nil, fmt.Errorf("could not read spool segment %d: %s", seg.seq, err)
+		}
+
+		if s.maxAge > 0 && time.Since(rec.Time) > s.maxAge {
+			s.dropped++
+			s.advance(n, rec.Item)
+			continue
+		}
+
+		s.head = rec.Item
+		s.headSize = n
+	}
+
+	return s.head, nil
+}
+
+// ack removes the item returned by peek
+func (s *spool) ack() error {
+	if s.head == nil {
+		return nil
+	}
+
+	s.advance(s.headSize, s.head)
+	s.head = nil
+	s.headSize = 0
+
+	if s.segments[0].count == 0 {
+		return s.removeOldest()
+	}
+
+	return s.saveCursor()
+}
+
+func (s *spool) advance(n int64, item *DataItem) {
+	seg := s.segments[0]
+
+	s.offset += n
+	s.count--
+	seg.count--
+	seg.dests[item.destination()]--
+	if seg.dests[item.destination()] <= 0 {
+		delete(seg.dests, item.destination())
+	}
+}
+
+func (s *spool) read(seg *spoolSegment) (*spoolRecord, int64, error) {
+	f, err := os.Open(s.path(seg.seq))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(s.offset, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return readSpoolRecord(f)
+}
+
+// dropOldest discards the oldest segment and all its unpublished records
+func (s *spool) dropOldest() error {
+	s.dropped += uint64(s.segments[0].count)
+	s.count -= s.segments[0].count
+	s.segments[0].count = 0
+	s.segments[0].dests = nil
+
+	return s.removeOldest()
+}
+
+// removeOldest deletes the oldest segment, the segment being written to is
+// replaced with a new one first
+func (s *spool) removeOldest() error {
+	seg := s.segments[0]
+
+	if len(s.segments) == 1 {
+		err := s.rotate(seg.seq + 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.segments = s.segments[1:]
+	s.bytes -= seg.size
+	s.offset = 0
+	s.head = nil
+	s.headSize = 0
+
+	err := s.saveCursor()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(s.path(seg.seq))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove spool segment: %s", err)
+	}
+
+	return syncDir(s.dir)
+}
+
+func (s *spool) saveCursor() error {
+	j, err := json.Marshal(spoolCursor{Segment: s.segments[0].seq, Offset: s.offset})
+	if err != nil {
+		return err
+	}
+
+	err = syncWriteFile(filepath.Join(s.dir, spoolCursorFile), j)
+	if err != nil {
+		return fmt.Errorf("could not save spool cursor: %s", err)
+	}
+
+	return nil
+}
+
+// syncWriteFile atomically replaces target with data, the data is synced to disk before the
+// rename and the directory after it so that target is never empty or partially written
+func syncWriteFile(target string, data []byte) error {
+	tf, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tf.Name())
+
+	_, err = tf.Write(data)
+	if err == nil {
+		err = tf.Sync()
+	}
+	if err != nil {
+		tf.Close()
+		return err
+	}
+
+	err = tf.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tf.Name(), target)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(target))
+}
+
+// syncDir syncs the entries of dir to disk, directories can not be synced on windows
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func (s *spool) close() error {
+	if s.writer == nil {
+		return nil
+	}
+
+	return s.writer.Close()
+}
+
+// readSpoolRecord reads and verifies the next record from r returning it and its size on disk
+func readSpoolRecord(r io.Reader) (*spoolRecord, int64, error) {
+	hdr := make([]byte, spoolHeaderSize)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(hdr[0:4])
+	if length > spoolMaxRecord {
+		return nil, 0, errSpoolCorrupt
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return nil, 0, errSpoolCorrupt
+	}
+
+	rec := &spoolRecord{}
+	err = json.Unmarshal(body, rec)
+	if err != nil || rec.Item == nil {
+		return nil, 0, errSpoolCorrupt
+	}
+
+	return rec, int64(length) + spoolHeaderSize, nil
+}
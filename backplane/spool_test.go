@@ -0,0 +1,223 @@
+package backplane
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func spoolTestDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatalf("could not create spool directory: %s", err)
+	}
+
+	return dir
+}
+
+func openTestSpool(t *testing.T, dir string, maxBytes int64, maxAge time.Duration) (*spool, *[]string) {
+	t.Helper()
+
+	warnings := &[]string{}
+	s, err := newSpool(dir, maxBytes, maxAge, func(format string, args ...interface{}) {
+		*warnings = append(*warnings, fmt.Sprintf(format, args...))
+	})
+	if err != nil {
+		t.Fatalf("could not open spool: %s", err)
+	}
+
+	return s, warnings
+}
+
+func pushTestItems(t *testing.T, s *spool, items ...string) {
+	t.Helper()
+
+	for _, d := range items {
+		err := s.push(&DataItem{Data: []byte(d), Destination: "test"})
+		if err != nil {
+			t.Fatalf("could not push %s: %s", d, err)
+		}
+	}
+}
+
+// drainSpool publishes every item in the spool returning their data in order
+func drainSpool(t *testing.T, s *spool) []string {
+	t.Helper()
+
+	found := []string{}
+	for {
+		item, err := s.peek()
+		if err != nil {
+			t.Fatalf("could not peek: %s", err)
+		}
+
+		if item == nil {
+			return found
+		}
+
+		found = append(found, string(item.Data))
+
+		err = s.ack()
+		if err != nil {
+			t.Fatalf("could not ack: %s", err)
+		}
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+	dir := spoolTestDir(t)
+	defer os.RemoveAll(dir)
+
+	s, _ := openTestSpool(t, dir, 0, 0)
+	pushTestItems(t, s, "1", "2", "3")
+
+	item, _ := s.peek()
+	if string(item.Data) != "1" {
+		t.Fatalf("expected item 1 got %s", item.Data)
+	}
+	s.ack()
+	s.close()
+
+	s, warnings := openTestSpool(t, dir, 0, 0)
+	defer s.close()
+
+	if s.len() != 2 {
+		t.Fatalf("expected 2 items after reopening got %d", s.len())
+	}
+
+	pushTestItems(t, s, "4")
+
+	found := drainSpool(t, s)
+	if strings.Join(found, ",") != "2,3,4" {
+		t.Fatalf("expected items 2,3,4 got %v", found)
+	}
+
+	if len(*warnings) != 0 {
+		t.Fatalf("unexpected warnings %v", *warnings)
+	}
+}
+
+func TestSpoolTruncatedSegment(t *testing.T) {
+	dir := spoolTestDir(t)
+	defer os.RemoveAll(dir)
+
+	s, _ := openTestSpool(t, dir, 0, 0)
+	pushTestItems(t, s, "1", "2")
+	seg := s.path(s.segments[0].seq)
+	size := s.segments[0].size
+	s.close()
+
+	// a crash while writing the second record leaves part of it behind
+	err := os.Truncate(seg, size-3)
+	if err != nil {
+		t.Fatalf("could not truncate segment: %s", err)
+	}
+
+	s, _ = openTestSpool(t, dir, 0, 0)
+	defer s.close()
+
+	if s.len() != 1 {
+		t.Fatalf("expected the torn record to be discarded leaving 1 item got %d", s.len())
+	}
+
+	pushTestItems(t, s, "3")
+
+	found := drainSpool(t, s)
+	if strings.Join(found, ",") != "1,3" {
+		t.Fatalf("expected items 1,3 got %v", found)
+	}
+}
+
+func TestSpoolCorruptCursor(t *testing.T) {
+	dir := spoolTestDir(t)
+	defer os.RemoveAll(dir)
+
+	s, _ := openTestSpool(t, dir, 0, 0)
+	pushTestItems(t, s, "1", "2", "3")
+	s.peek()
+	s.ack()
+	s.close()
+
+	err := ioutil.WriteFile(filepath.Join(dir, spoolCursorFile), []byte(`{"segm`), 0600)
+	if err != nil {
+		t.Fatalf("could not corrupt cursor: %s", err)
+	}
+
+	s, warnings := openTestSpool(t, dir, 0, 0)
+	defer s.close()
+
+	if len(*warnings) != 1 || !strings.Contains((*warnings)[0], "spool cursor") {
+		t.Fatalf("expected a warning about the cursor got %v", *warnings)
+	}
+
+	// the published item is published again rather than losing the others
+	found := drainSpool(t, s)
+	if strings.Join(found, ",") != "1,2,3" {
+		t.Fatalf("expected items 1,2,3 got %v", found)
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	dir := spoolTestDir(t)
+	defer os.RemoveAll(dir)
+
+	s, _ := openTestSpool(t, dir, 1024, 0)
+	defer s.close()
+
+	for i := 0; i < 100; i++ {
+		pushTestItems(t, s, fmt.Sprintf("%03d", i))
+
+		if s.bytes > 1024 {
+			t.Fatalf("spool grew to %d bytes beyond its 1024 byte limit", s.bytes)
+		}
+	}
+
+	if s.dropped == 0 {
+		t.Fatalf("expected items to be dropped")
+	}
+
+	if uint64(s.len())+s.dropped != 100 {
+		t.Fatalf("expected %d queued and %d dropped items to add up to 100", s.len(), s.dropped)
+	}
+
+	queued := s.len()
+
+	found := drainSpool(t, s)
+	if len(found) != queued || found[len(found)-1] != "099" {
+		t.Fatalf("expected the %d newest items to be kept got %v", queued, found)
+	}
+
+	// the oldest items were dropped so the remaining ones are the most recent in order
+	for i := 1; i < len(found); i++ {
+		if found[i] <= found[i-1] {
+			t.Fatalf("items are not in order: %v", found)
+		}
+	}
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	dir := spoolTestDir(t)
+	defer os.RemoveAll(dir)
+
+	s, _ := openTestSpool(t, dir, 0, 50*time.Millisecond)
+	defer s.close()
+
+	pushTestItems(t, s, "1", "2")
+	time.Sleep(100 * time.Millisecond)
+	pushTestItems(t, s, "3")
+
+	found := drainSpool(t, s)
+	if strings.Join(found, ",") != "3" {
+		t.Fatalf("expected only item 3 got %v", found)
+	}
+
+	if s.dropped != 2 {
+		t.Fatalf("expected 2 expired items got %d", s.dropped)
+	}
+}
@@ -0,0 +1,87 @@
+package backplane
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SRVResolver looks up SRV records, it has the same signature as net.LookupSRV
+type SRVResolver func(service string, proto string, name string) (cname string, addrs []*net.SRV, err error)
+
+// SRVConfigProvider can optionally be implemented by a ConfigProvider to enable SRV based broker discovery
+type SRVConfigProvider interface {
+	// SRVDomain is the domain to query for broker SRV records, "" disables SRV lookups
+	SRVDomain() string
+}
+
+// srvServices are the SRV services queried in order, the same ones Choria uses
+var srvServices = []string{"mcollective-server", "x-puppet-mcollective"}
+
+// resolveBrokers determines the brokers to connect to, when a SRV domain is configured
+// the SRV records are used and the static brokers only when no records are found
+func (c *Config) resolveBrokers() []string {
+	if c.srvDomain == "" {
+		return c.brokers
+	}
+
+	log := c.fw.Logger("backplane")
+
+	for _, service := range srvServices {
+		_, addrs, err := c.srvResolver(service, "tcp", c.srvDomain)
+		if err != nil {
+			log.Debugf("Could not resolve SRV records for _%s._tcp.%s: %s", service, c.srvDomain, err)
+			continue
+		}
+
+		brokers := srvToBrokers(addrs)
+		if len(brokers) > 0 {
+			log.Infof("Found brokers %s using SRV records for _%s._tcp.%s", strings.Join(brokers, ", "), service, c.srvDomain)
+			return brokers
+		}
+	}
+
+	if len(c.brokers) > 0 {
+		log.Warnf("No broker SRV records found in %s, using configured brokers %s", c.srvDomain, strings.Join(c.brokers, ", "))
+	} else {
+		log.Errorf("No broker SRV records found in %s and no brokers are configured", c.srvDomain)
+	}
+
+	return c.brokers
+}
+
+// srvToBrokers converts SRV records to brokers ordered by priority, records with the same
+// priority are ordered by weight with the heaviest first
+func srvToBrokers(addrs []*net.SRV) []string {
+	var brokers []string
+
+	sorted := make([]*net.SRV, len(addrs))
+	copy(sorted, addrs)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+
+		return sorted[i].Weight > sorted[j].Weight
+	})
+
+	for _, addr := range sorted {
+		host := strings.TrimSuffix(addr.Target, ".")
+		if host == "" {
+			continue
+		}
+
+		brokers = append(brokers, net.JoinHostPort(host, strconv.Itoa(int(addr.Port))))
+	}
+
+	return brokers
+}
+
+// SRVLookups configures a custom resolver for SRV based broker discovery, net.LookupSRV is used by default
+func SRVLookups(r SRVResolver) Option {
+	return func(c *Config) {
+		c.srvResolver = r
+	}
+}
@@ -0,0 +1,159 @@
+package backplane
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type stubResolver struct {
+	records map[string][]*net.SRV
+	err     error
+	queries []string
+}
+
+func (s *stubResolver) lookup(service string, proto string, name string) (string, []*net.SRV, error) {
+	query := fmt.Sprintf("_%s._%s.%s", service, proto, name)
+	s.queries = append(s.queries, query)
+
+	if s.err != nil {
+		return "", nil, s.err
+	}
+
+	return query, s.records[query], nil
+}
+
+func srvTestConfig(t *testing.T, brokers []string, resolver *stubResolver) *Config {
+	t.Helper()
+
+	conf := &StandardConfiguration{
+		AppName:       "srvtest",
+		Brokers:       brokers,
+		SRVDomainName: "example.net",
+		Authorization: Authorization{Insecure: true},
+	}
+
+	c, err := newConfig("backplane", conf, SRVLookups(resolver.lookup))
+	if err != nil {
+		t.Fatalf("could not create configuration: %s", err)
+	}
+
+	err = c.setupFramework(nil)
+	if err != nil {
+		t.Fatalf("could not create framework: %s", err)
+	}
+
+	return c
+}
+
+func TestResolveBrokersUsesSRVRecords(t *testing.T) {
+	resolver := &stubResolver{
+		records: map[string][]*net.SRV{
+			"_mcollective-server._tcp.example.net": {
+				{Target: "broker1.example.net.", Port: 4222, Priority: 1, Weight: 1},
+			},
+		},
+	}
+
+	c := srvTestConfig(t, []string{"static.example.net:4222"}, resolver)
+
+	brokers := c.resolveBrokers()
+	expected := []string{"broker1.example.net:4222"}
+	if !reflect.DeepEqual(brokers, expected) {
+		t.Fatalf("expected brokers %v got %v", expected, brokers)
+	}
+
+	if len(resolver.queries) != 1 {
+		t.Fatalf("expected a single SRV query got %v", resolver.queries)
+	}
+}
+
+func TestResolveBrokersTriesLegacyService(t *testing.T) {
+	resolver := &stubResolver{
+		records: map[string][]*net.SRV{
+			"_x-puppet-mcollective._tcp.example.net": {
+				{Target: "legacy.example.net.", Port: 4223},
+			},
+		},
+	}
+
+	c := srvTestConfig(t, nil, resolver)
+
+	brokers := c.resolveBrokers()
+	expected := []string{"legacy.example.net:4223"}
+	if !reflect.DeepEqual(brokers, expected) {
+		t.Fatalf("expected brokers %v got %v", expected, brokers)
+	}
+}
+
+func TestResolveBrokersOrdersByPriorityAndWeight(t *testing.T) {
+	resolver := &stubResolver{
+		records: map[string][]*net.SRV{
+			"_mcollective-server._tcp.example.net": {
+				{Target: "backup.example.net.", Port: 4222, Priority: 20, Weight: 100},
+				{Target: "light.example.net.", Port: 4222, Priority: 10, Weight: 10},
+				{Target: "heavy.example.net.", Port: 4222, Priority: 10, Weight: 50},
+			},
+		},
+	}
+
+	c := srvTestConfig(t, nil, resolver)
+
+	brokers := c.resolveBrokers()
+	expected := []string{"heavy.example.net:4222", "light.example.net:4222", "backup.example.net:4222"}
+	if !reflect.DeepEqual(brokers, expected) {
+		t.Fatalf("expected brokers %v got %v", expected, brokers)
+	}
+}
+
+func TestResolveBrokersFallsBackWhenLookupFails(t *testing.T) {
+	resolver := &stubResolver{err: fmt.Errorf("simulated failure")}
+
+	c := srvTestConfig(t, []string{"static.example.net:4222"}, resolver)
+
+	brokers := c.resolveBrokers()
+	expected := []string{"static.example.net:4222"}
+	if !reflect.DeepEqual(brokers, expected) {
+		t.Fatalf("expected brokers %v got %v", expected, brokers)
+	}
+
+	if len(resolver.queries) != len(srvServices) {
+		t.Fatalf("expected every SRV service to be queried got %v", resolver.queries)
+	}
+}
+
+func TestResolveBrokersFallsBackWhenNoRecords(t *testing.T) {
+	resolver := &stubResolver{
+		records: map[string][]*net.SRV{
+			"_mcollective-server._tcp.example.net": {
+				{Target: ".", Port: 4222},
+			},
+		},
+	}
+
+	c := srvTestConfig(t, []string{"static.example.net:4222"}, resolver)
+
+	brokers := c.resolveBrokers()
+	expected := []string{"static.example.net:4222"}
+	if !reflect.DeepEqual(brokers, expected) {
+		t.Fatalf("expected brokers %v got %v", expected, brokers)
+	}
+}
+
+func TestResolveBrokersWithoutSRVDomain(t *testing.T) {
+	resolver := &stubResolver{}
+
+	c := srvTestConfig(t, []string{"static.example.net:4222"}, resolver)
+	c.srvDomain = ""
+
+	brokers := c.resolveBrokers()
+	expected := []string{"static.example.net:4222"}
+	if !reflect.DeepEqual(brokers, expected) {
+		t.Fatalf("expected brokers %v got %v", expected, brokers)
+	}
+
+	if len(resolver.queries) != 0 {
+		t.Fatalf("expected no SRV queries got %v", resolver.queries)
+	}
+}
@@ -1,14 +1,23 @@
 package backplane
 
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
 // StandardConfiguration implements ConfigProvider
 // you can use this as a helper in your own code
 // to give users the ability to configure the backplane
 type StandardConfiguration struct {
 	Brokers       []string      `json:"brokers" yaml:"brokers"`
+	SRVDomainName string        `json:"srv_domain" yaml:"srv_domain"`
 	AppName       string        `json:"name" yaml:"name"`
 	LogFilePath   string        `json:"logfile" yaml:"logfile"`
 	Loglevel      string        `json:"loglevel" yaml:"loglevel"`
 	TLSConf       *TLSConf      `json:"tls" yaml:"tls"`
+	NATSConf      *NATSConf     `json:"nats" yaml:"nats"`
 	Authorization Authorization `json:"auth" yaml:"auth"`
 }
 
@@ -17,6 +26,11 @@ func (s *StandardConfiguration) MiddlewareHosts() []string {
 	return s.Brokers
 }
 
+// SRVDomain is the domain to query for broker SRV records
+func (s *StandardConfiguration) SRVDomain() string {
+	return s.SRVDomainName
+}
+
 // Name is a name for the application which will be used as a name for the collective the nodes are in
 func (s *StandardConfiguration) Name() string {
 	return s.AppName
@@ -37,7 +51,33 @@ func (s *StandardConfiguration) TLS() *TLSConf {
 	return s.TLSConf
 }
 
+// NATS is the NATS authentication configuration
+func (s *StandardConfiguration) NATS() *NATSConf {
+	return s.NATSConf
+}
+
 // Auth is the authorized certificates for the backplane
 func (s *StandardConfiguration) Auth() Authorization {
 	return s.Authorization
 }
+
+// LoadStandardConfiguration reads a YAML file holding a StandardConfiguration
+func LoadStandardConfiguration(file string) (*StandardConfiguration, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", file, err)
+	}
+
+	return parseStandardConfiguration(body)
+}
+
+func parseStandardConfiguration(body []byte) (*StandardConfiguration, error) {
+	conf := &StandardConfiguration{}
+
+	err := yaml.Unmarshal(body, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,64 @@
+package backplane
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// kubernetesFacts maps facts to the environment variables commonly set using the Kubernetes downward API
+var kubernetesFacts = []struct {
+	fact string
+	env  string
+}{
+	{"backplane_k8s_pod_name", "POD_NAME"},
+	{"backplane_k8s_namespace", "POD_NAMESPACE"},
+	{"backplane_k8s_node_name", "NODE_NAME"},
+	{"backplane_k8s_pod_ip", "POD_IP"},
+	{"backplane_k8s_service_account", "POD_SERVICE_ACCOUNT"},
+}
+
+// standardFacts are facts about the process and the environment it runs in, they do not
+// change while the process runs so are only determined once
+func standardFacts(started time.Time) map[string]interface{} {
+	facts := map[string]interface{}{
+		"backplane_go_version": runtime.Version(),
+		"backplane_os":         runtime.GOOS,
+		"backplane_arch":       runtime.GOARCH,
+		"backplane_pid":        os.Getpid(),
+		"backplane_start_time": started.UTC().Format(time.RFC3339),
+		"backplane_container":  inContainer(),
+		"backplane_kubernetes": os.Getenv("KUBERNETES_SERVICE_HOST") != "",
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		facts["backplane_hostname"] = hostname
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		facts["backplane_module"] = bi.Main.Path
+		facts["backplane_module_version"] = bi.Main.Version
+
+		addVCSFacts(bi, facts)
+	}
+
+	for _, k := range kubernetesFacts {
+		if v := os.Getenv(k.env); v != "" {
+			facts[k.fact] = v
+		}
+	}
+
+	return facts
+}
+
+// inContainer detects Docker and Podman containers using the files they create
+func inContainer() bool {
+	for _, f := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(f); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build !go1.18
+// +build !go1.18
+
+package backplane
+
+import "runtime/debug"
+
+// addVCSFacts does nothing as binaries built before go 1.18 do not hold version control details
+func addVCSFacts(bi *debug.BuildInfo, facts map[string]interface{}) {}
@@ -0,0 +1,20 @@
+//go:build go1.18
+// +build go1.18
+
+package backplane
+
+import "runtime/debug"
+
+// addVCSFacts adds the version control details embedded in the binary since go 1.18
+func addVCSFacts(bi *debug.BuildInfo, facts map[string]interface{}) {
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			facts["backplane_vcs_revision"] = s.Value
+		case "vcs.time":
+			facts["backplane_vcs_time"] = s.Value
+		case "vcs.modified":
+			facts["backplane_vcs_modified"] = s.Value == "true"
+		}
+	}
+}
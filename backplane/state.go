@@ -0,0 +1,201 @@
+package backplane
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ConnectionState is the state of the connection to the Choria Broker
+type ConnectionState int
+
+const (
+	// Connecting is the state while the initial connection to the broker is being established
+	Connecting ConnectionState = iota
+
+	// Connected is the state when the backplane is connected to the broker
+	Connected
+
+	// Reconnecting is the state while a lost connection is being re-established
+	Reconnecting
+
+	// Closed is the state once the backplane has been shut down
+	Closed
+)
+
+// StateChangeHandler is called when the connection state changes
+type StateChangeHandler func(from ConnectionState, to ConnectionState)
+
+// String returns the name of the connection state
+func (s ConnectionState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case Closed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Connected determines if the backplane is connected to the broker
+func (m *Management) Connected() bool {
+	return m.State() == Connected
+}
+
+// State is the current state of the connection to the broker
+func (m *Management) State() ConnectionState {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	return m.state
+}
+
+// StateSince is the time the connection entered its current state
+func (m *Management) StateSince() time.Time {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	return m.stateSince
+}
+
+func (m *Management) setState(s ConnectionState) {
+	m.stateMu.Lock()
+	previous := m.state
+	if previous == s {
+		m.stateMu.Unlock()
+		return
+	}
+
+	m.state = s
+	m.stateSince = time.Now()
+	if s == Connected {
+		atomic.StoreUint32(&m.connected, 1)
+	} else {
+		atomic.StoreUint32(&m.connected, 0)
+	}
+	m.stateMu.Unlock()
+
+	m.log.Infof("Backplane connection state changed from %s to %s", previous, s)
+
+	for _, handler := range m.cfg.stateHandlers {
+		handler(previous, s)
+	}
+}
+
+// isConnected determines if the backplane is connected without taking any locks, it is
+// safe to call while holding the locks of the data outbox
+func (m *Management) isConnected() bool {
+	return atomic.LoadUint32(&m.connected) == 1
+}
+
+// watchConnection follows the state of the connection of a new instance using the NATS
+// connection callbacks, the callbacks installed by Choria are still called
+func (m *Management) watchConnection() {
+	nc := m.natsConn()
+	if nc == nil {
+		return
+	}
+
+	disconnected := nc.Opts.DisconnectedErrCB
+	reconnected := nc.Opts.ReconnectedCB
+	closed := nc.Opts.ClosedCB
+
+	nc.SetDisconnectErrHandler(func(c *nats.Conn, err error) {
+		if disconnected != nil {
+			disconnected(c, err)
+		}
+
+		m.connectionStateChanged(c, Reconnecting)
+	})
+
+	nc.SetReconnectHandler(func(c *nats.Conn) {
+		if reconnected != nil {
+			reconnected(c)
+		}
+
+		m.connectionStateChanged(c, Connected)
+	})
+
+	nc.SetClosedHandler(func(c *nats.Conn) {
+		if closed != nil {
+			closed(c)
+		}
+
+		// a closed connection while we are still running means the server is being restarted
+		m.connectionStateChanged(c, Reconnecting)
+	})
+}
+
+// connectionStateChanged records a state reported by the callbacks of nc, callbacks from
+// connections of instances that were since replaced or after shutdown are ignored
+func (m *Management) connectionStateChanged(nc *nats.Conn, state ConnectionState) {
+	if m.ctx.Err() != nil || m.natsConn() != nc {
+		return
+	}
+
+	m.setState(state)
+}
+
+// ConnectionHealthThreshold makes the health action report the application as unhealthy once the
+// backplane has not been connected to the broker for longer than threshold, the HealthCheckable
+// given using ManageHealthCheck, if any, is still checked and its result included
+func ConnectionHealthThreshold(threshold time.Duration) Option {
+	return func(c *Config) {
+		c.healthThreshold = threshold
+	}
+}
+
+// connectionHealthCheck is a HealthCheckable that reports the application as unhealthy
+// when the backplane has been disconnected from the broker for too long
+type connectionHealthCheck struct {
+	mgmt      *Management
+	check     HealthCheckable
+	threshold time.Duration
+}
+
+// ConnectionHealthResult is the health check result reported when using ConnectionHealthThreshold
+type ConnectionHealthResult struct {
+	Backplane       string      `json:"backplane"`
+	DisconnectedFor string      `json:"disconnected_for,omitempty"`
+	Result          interface{} `json:"result,omitempty"`
+}
+
+// newConnectionHealthCheck wraps check, which can be nil, with a health check that fails once the
+// backplane has not been connected to the broker for longer than threshold
+func newConnectionHealthCheck(m *Management, check HealthCheckable, threshold time.Duration) *connectionHealthCheck {
+	return &connectionHealthCheck{
+		mgmt:      m,
+		check:     check,
+		threshold: threshold,
+	}
+}
+
+// HealthCheck implements HealthCheckable
+func (c *connectionHealthCheck) HealthCheck() (result interface{}, ok bool) {
+	state := c.mgmt.State()
+	res := &ConnectionHealthResult{
+		Backplane: state.String(),
+	}
+
+	ok = true
+
+	if state != Connected {
+		since := time.Since(c.mgmt.StateSince())
+		res.DisconnectedFor = since.Round(time.Second).String()
+		ok = since < c.threshold
+	}
+
+	if c.check != nil {
+		var checkOk bool
+		res.Result, checkOk = c.check.HealthCheck()
+		ok = ok && checkOk
+	}
+
+	return res, ok
+}
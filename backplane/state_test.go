@@ -0,0 +1,59 @@
+package backplane
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type stubHealth struct {
+	ok bool
+}
+
+func (s *stubHealth) HealthCheck() (interface{}, bool) {
+	return "checked", s.ok
+}
+
+func TestConnectionHealthCheck(t *testing.T) {
+	cases := []struct {
+		name    string
+		state   ConnectionState
+		since   time.Duration
+		check   HealthCheckable
+		healthy bool
+	}{
+		{"connected", Connected, time.Hour, nil, true},
+		{"briefly disconnected", Reconnecting, time.Second, nil, true},
+		{"disconnected too long", Reconnecting, time.Hour, nil, false},
+		{"never connected", Connecting, time.Hour, nil, false},
+		{"connected but unhealthy", Connected, time.Hour, &stubHealth{ok: false}, false},
+		{"connected and healthy", Connected, time.Hour, &stubHealth{ok: true}, true},
+		{"disconnected too long and healthy", Reconnecting, time.Hour, &stubHealth{ok: true}, false},
+	}
+
+	for _, tc := range cases {
+		m := &Management{
+			stateMu:    &sync.Mutex{},
+			state:      tc.state,
+			stateSince: time.Now().Add(-tc.since),
+		}
+
+		res, ok := newConnectionHealthCheck(m, tc.check, time.Minute).HealthCheck()
+		if ok != tc.healthy {
+			t.Fatalf("%s: expected healthy %v got %v", tc.name, tc.healthy, ok)
+		}
+
+		result := res.(*ConnectionHealthResult)
+		if result.Backplane != tc.state.String() {
+			t.Fatalf("%s: expected state %s got %s", tc.name, tc.state, result.Backplane)
+		}
+
+		if tc.check != nil && result.Result != "checked" {
+			t.Fatalf("%s: expected the wrapped check result got %v", tc.name, result.Result)
+		}
+
+		if tc.state != Connected && result.DisconnectedFor == "" {
+			t.Fatalf("%s: expected the disconnected duration to be reported", tc.name)
+		}
+	}
+}
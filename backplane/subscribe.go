@@ -0,0 +1,404 @@
+package backplane
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/go-choria/protocol"
+	"github.com/choria-io/go-choria/providers/agent/mcorpc/audit"
+	"github.com/nats-io/nats.go"
+)
+
+// Message is a Choria request received by a subscription, its signature and caller were
+// verified and the caller is allowed full access by the backplane authorization
+type Message struct {
+	// Subject is the subject the message was received on, relative to the <name>_backplane.app scope
+	Subject string
+
+	// Data is the message body
+	Data []byte
+
+	// RequestID is the id of the Choria request
+	RequestID string
+
+	// CallerID is the verified caller that sent the request
+	CallerID string
+
+	// SenderID is the identity of the node that sent the request
+	SenderID string
+
+	reply string
+	m     *Management
+}
+
+// MessageHandler handles messages received by a subscription, messages for a subscription
+// are handled one at a time in the order they were received
+type MessageHandler func(msg *Message)
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*Subscription)
+
+// SubscriptionStats are counters describing a subscription
+type SubscriptionStats struct {
+	// Subject is the subject subscribed to, relative to the application scope
+	Subject string `json:"subject"`
+
+	// Received is how many messages were received
+	Received uint64 `json:"received"`
+
+	// Rejected is how many messages were discarded because they were not valid Choria
+	// requests, had expired or the caller was not authorized
+	Rejected uint64 `json:"rejected"`
+
+	// Dropped is how many messages were discarded because the buffer was full
+	Dropped uint64 `json:"dropped"`
+
+	// Pending is how many messages are waiting to be handled
+	Pending int `json:"pending"`
+}
+
+// Subscription is an active subscription created using Subscribe
+type Subscription struct {
+	m       *Management
+	subject string
+	target  string
+	group   string
+	buffer  int
+	handler MessageHandler
+
+	mu       *sync.Mutex
+	msgs     chan *Message
+	quit     chan struct{}
+	nsub     *nats.Subscription
+	received uint64
+	rejected uint64
+	dropped  uint64
+	closed   bool
+}
+
+// SubscriptionBuffer sets how many messages are held while the handler is busy, once full
+// new messages are dropped and counted in the subscription stats, 1000 is default
+func SubscriptionBuffer(n int) SubscribeOption {
+	return func(s *Subscription) {
+		s.buffer = n
+	}
+}
+
+// SubscriptionQueueGroup delivers every message to only one of the instances subscribed
+// using the same group rather than to all instances
+func SubscriptionQueueGroup(group string) SubscribeOption {
+	return func(s *Subscription) {
+		s.group = group
+	}
+}
+
+// Subscribe calls handler for every message received on subject, subject is relative to the
+// <name>_backplane.app scope and may use the * and > wildcards, subscribing to config.* receives
+// messages published to <name>_backplane.app.config.*.  The subscription uses the connection of
+// the backplane and is restored whenever the backplane reconnects, when not connected it becomes
+// active once connected.
+//
+// Messages must be Choria requests, their signature and caller are verified by the security
+// provider and the caller has to be allowed full access by the backplane authorization.  Accepted
+// messages are written to the audit log when auditing is enabled before the handler is called,
+// other messages are rejected and counted in the subscription stats
+func (m *Management) Subscribe(subject string, handler MessageHandler, opts ...SubscribeOption) (*Subscription, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("a message handler is required")
+	}
+
+	err := validateSubject(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Subscription{
+		m:       m,
+		subject: subject,
+		target:  m.SubscriptionSubject(subject),
+		buffer:  1000,
+		handler: handler,
+		mu:      &sync.Mutex{},
+		quit:    make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.buffer < 1 {
+		return nil, fmt.Errorf("the subscription buffer must be at least 1")
+	}
+
+	s.msgs = make(chan *Message, s.buffer)
+
+	m.subsMu.Lock()
+	m.subs[s] = struct{}{}
+	m.subsMu.Unlock()
+
+	m.wg.Add(1)
+	go s.worker()
+
+	conn := m.natsConn()
+	if conn != nil {
+		err = s.subscribe(conn)
+		if err != nil {
+			s.Unsubscribe()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// SubscriptionSubject is the full subject that a subscription to subject listens on, this is
+// <name>_backplane.app.<subject>
+func (m *Management) SubscriptionSubject(subject string) string {
+	return fmt.Sprintf("%s.app.%s", m.cfg.appname, subject)
+}
+
+// Subscriptions reports counters for all active subscriptions sorted by subject
+func (m *Management) Subscriptions() []SubscriptionStats {
+	m.subsMu.Lock()
+	defer m.subsMu.Unlock()
+
+	stats := []SubscriptionStats{}
+	for s := range m.subs {
+		stats = append(stats, s.Stats())
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Subject < stats[j].Subject })
+
+	return stats
+}
+
+// framework is the Choria framework of the running configuration
+func (m *Management) framework() *choria.Framework {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cfg.fw
+}
+
+// natsConn is the connection of the running instance, nil when there is none
+func (m *Management) natsConn() *nats.Conn {
+	m.mu.Lock()
+	srv := m.cserver
+	m.mu.Unlock()
+
+	if srv == nil || srv.Connector() == nil {
+		return nil
+	}
+
+	return srv.Connector().Nats()
+}
+
+// startSubscriptions subscribes all subscriptions using the connection of a new instance
+func (m *Management) startSubscriptions() {
+	conn := m.natsConn()
+	if conn == nil {
+		return
+	}
+
+	m.subsMu.Lock()
+	defer m.subsMu.Unlock()
+
+	for s := range m.subs {
+		err := s.subscribe(conn)
+		if err != nil {
+			m.log.Errorf("Could not restore subscription to %s: %s", s.target, err)
+		}
+	}
+}
+
+func (s *Subscription) subscribe(conn *nats.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// already subscribed using the current connection
+	if s.closed || s.nsub.IsValid() {
+		return nil
+	}
+
+	var err error
+	if s.group == "" {
+		s.nsub, err = conn.Subscribe(s.target, s.receive)
+	} else {
+		s.nsub, err = conn.QueueSubscribe(s.target, s.group, s.receive)
+	}
+	if err != nil {
+		return fmt.Errorf("could not subscribe to %s: %s", s.target, err)
+	}
+
+	return nil
+}
+
+// receive verifies and audits a message and queues it for the handler, dropping it when the buffer is full
+func (s *Subscription) receive(nm *nats.Msg) {
+	msg, err := s.verify(nm)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.received++
+
+	if err != nil {
+		s.rejected++
+		if s.rejected == 1 || s.rejected%1000 == 0 {
+			s.m.log.Warnf("Rejected %d messages received on %s, the most recent: %s", s.rejected, s.target, err)
+		}
+		return
+	}
+
+	select {
+	case s.msgs <- msg:
+	default:
+		s.dropped++
+		if s.dropped == 1 || s.dropped%1000 == 0 {
+			s.m.log.Warnf("Dropped %d messages received on %s, the handler is not keeping up", s.dropped, s.target)
+		}
+	}
+}
+
+// verify decodes nm as a Choria request using the security provider, checks that it did not
+// expire and that the caller is allowed full access and writes it to the audit log
+func (s *Subscription) verify(nm *nats.Msg) (*Message, error) {
+	fw := s.m.framework()
+
+	req, err := fw.NewRequestFromTransportJSON(nm.Data, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %s", err)
+	}
+
+	if time.Since(req.Time()) > time.Duration(req.TTL())*time.Second {
+		return nil, fmt.Errorf("request %s from %s created at %s has expired, ttl is %d", req.RequestID(), req.CallerID(), req.Time(), req.TTL())
+	}
+
+	auth := s.m.authorization()
+	if !auth.FullAllowed(req.CallerID()) {
+		return nil, fmt.Errorf("request %s from %s is not authorized", req.RequestID(), req.CallerID())
+	}
+
+	msg := &Message{
+		Subject:   strings.TrimPrefix(nm.Subject, s.m.SubscriptionSubject("")),
+		Data:      []byte(req.Message()),
+		RequestID: req.RequestID(),
+		CallerID:  req.CallerID(),
+		SenderID:  req.SenderID(),
+		reply:     nm.Reply,
+		m:         s.m,
+	}
+
+	s.audit(fw, req, msg)
+
+	return msg, nil
+}
+
+// audit writes an accepted message to the Choria audit log using the subscription subject as action
+func (s *Subscription) audit(fw *choria.Framework, req protocol.Request, msg *Message) {
+	data, err := json.Marshal(map[string]interface{}{"subject": msg.Subject, "size": len(msg.Data)})
+	if err != nil {
+		return
+	}
+
+	audit.Request(req, s.m.cfg.appname, s.subject, data, fw.Configuration())
+}
+
+func (s *Subscription) worker() {
+	defer s.m.wg.Done()
+
+	for {
+		select {
+		case msg := <-s.msgs:
+			s.handler(msg)
+		case <-s.quit:
+			return
+		case <-s.m.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stats reports counters for the subscription
+func (s *Subscription) Stats() SubscriptionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SubscriptionStats{
+		Subject:  s.subject,
+		Received: s.received,
+		Rejected: s.rejected,
+		Dropped:  s.dropped,
+		Pending:  len(s.msgs),
+	}
+}
+
+// Unsubscribe stops the subscription, messages that were not yet handled are discarded
+func (s *Subscription) Unsubscribe() error {
+	s.m.subsMu.Lock()
+	delete(s.m.subs, s)
+	s.m.subsMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	close(s.quit)
+
+	if !s.nsub.IsValid() {
+		return nil
+	}
+
+	err := s.nsub.Unsubscribe()
+	if err != nil {
+		return fmt.Errorf("could not unsubscribe from %s: %s", s.target, err)
+	}
+
+	return nil
+}
+
+// Respond replies to a message sent as a request
+func (msg *Message) Respond(data []byte) error {
+	if msg.reply == "" {
+		return fmt.Errorf("the message does not expect a reply")
+	}
+
+	conn := msg.m.natsConn()
+	if conn == nil {
+		return errNotConnected
+	}
+
+	return conn.Publish(msg.reply, data)
+}
+
+// validateSubject checks that subject is a valid NATS subject that can be scoped
+func validateSubject(subject string) error {
+	if subject == "" {
+		return fmt.Errorf("a subject is required")
+	}
+
+	tokens := strings.Split(subject, ".")
+	for i, t := range tokens {
+		switch {
+		case t == "":
+			return fmt.Errorf("invalid subject %q, subjects may not have empty tokens", subject)
+		case strings.ContainsAny(t, " \t\r\n"):
+			return fmt.Errorf("invalid subject %q, subjects may not contain white space", subject)
+		case t == ">" && i != len(tokens)-1:
+			return fmt.Errorf("invalid subject %q, > may only be the last token", subject)
+		case t != "*" && t != ">" && strings.ContainsAny(t, "*>"):
+			return fmt.Errorf("invalid subject %q, wildcards must be whole tokens", subject)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package backplane
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/choria-io/go-choria/protocol"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+func subscribeTestManagement(t *testing.T, auth Authorization) *Management {
+	t.Helper()
+
+	conf := &StandardConfiguration{
+		AppName:       "subtest",
+		Brokers:       []string{"localhost:4222"},
+		Authorization: auth,
+	}
+
+	c, err := newConfig("backplane", conf)
+	if err != nil {
+		t.Fatalf("could not create configuration: %s", err)
+	}
+
+	err = c.setupFramework(nil)
+	if err != nil {
+		t.Fatalf("could not create framework: %s", err)
+	}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	return &Management{cfg: c, mu: &sync.Mutex{}, log: logrus.NewEntry(logger)}
+}
+
+func subscribeTestRequest(t *testing.T, m *Management, caller string, ttl int) []byte {
+	t.Helper()
+
+	id, err := m.cfg.fw.NewRequestID()
+	if err != nil {
+		t.Fatalf("could not create request id: %s", err)
+	}
+
+	req, err := m.cfg.fw.NewRequest(protocol.RequestV1, "subtest", "sender.example.net", caller, ttl, id, m.cfg.appname)
+	if err != nil {
+		t.Fatalf("could not create request: %s", err)
+	}
+
+	req.SetMessage("hello world")
+
+	sreq, err := m.cfg.fw.NewSecureRequest(req)
+	if err != nil {
+		t.Fatalf("could not create secure request: %s", err)
+	}
+
+	transport, err := m.cfg.fw.NewTransportForSecureRequest(sreq)
+	if err != nil {
+		t.Fatalf("could not create transport: %s", err)
+	}
+
+	j, err := transport.JSON()
+	if err != nil {
+		t.Fatalf("could not encode transport: %s", err)
+	}
+
+	return []byte(j)
+}
+
+func TestSubscriptionSubject(t *testing.T) {
+	m := &Management{cfg: &Config{appname: "myapp_backplane"}}
+
+	subject := m.SubscriptionSubject("config.*")
+	if subject != "myapp_backplane.app.config.*" {
+		t.Fatalf("expected myapp_backplane.app.config.* got %s", subject)
+	}
+}
+
+func TestSubscriptionVerify(t *testing.T) {
+	m := subscribeTestManagement(t, Authorization{Full: []string{"^choria=admin$"}})
+	s := &Subscription{m: m, subject: "config.*", mu: &sync.Mutex{}}
+
+	msg, err := s.verify(&nats.Msg{Subject: "subtest_backplane.app.config.update", Reply: "reply.subject", Data: subscribeTestRequest(t, m, "choria=admin", 60)})
+	if err != nil {
+		t.Fatalf("expected the request to be accepted: %s", err)
+	}
+
+	if msg.Subject != "config.update" || string(msg.Data) != "hello world" || msg.CallerID != "choria=admin" || msg.SenderID != "sender.example.net" || msg.reply != "reply.subject" {
+		t.Fatalf("unexpected message %#v", msg)
+	}
+
+	if msg.RequestID == "" {
+		t.Fatalf("expected a request id")
+	}
+
+	for name, data := range map[string][]byte{
+		"unauthorized": subscribeTestRequest(t, m, "choria=other", 60),
+		"invalid":      []byte("hello world"),
+	} {
+		_, err = s.verify(&nats.Msg{Subject: "subtest_backplane.app.config.update", Data: data})
+		if err == nil {
+			t.Fatalf("expected the %s request to be rejected", name)
+		}
+	}
+}
+
+func TestSubscriptionReceiveCountsRejectedAndDropped(t *testing.T) {
+	m := subscribeTestManagement(t, Authorization{Insecure: true})
+	s := &Subscription{m: m, subject: "config.*", mu: &sync.Mutex{}, msgs: make(chan *Message, 1)}
+
+	for i := 0; i < 3; i++ {
+		s.receive(&nats.Msg{Subject: "subtest_backplane.app.config.update", Data: subscribeTestRequest(t, m, "choria=admin", 60)})
+	}
+	s.receive(&nats.Msg{Subject: "subtest_backplane.app.config.update", Data: []byte("hello world")})
+
+	stats := s.Stats()
+	if stats.Received != 4 || stats.Rejected != 1 || stats.Dropped != 2 || stats.Pending != 1 {
+		t.Fatalf("unexpected stats %#v", stats)
+	}
+}
+
+func TestValidateSubject(t *testing.T) {
+	for _, subject := range []string{"config", "config.*", "config.>", "*.updates"} {
+		err := validateSubject(subject)
+		if err != nil {
+			t.Fatalf("expected %q to be valid: %s", subject, err)
+		}
+	}
+
+	for _, subject := range []string{"", "config..x", "config.>.x", "con fig", "config.x*"} {
+		err := validateSubject(subject)
+		if err == nil {
+			t.Fatalf("expected %q to be invalid", subject)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package backplane
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// publishFlushTimeout limits how long PublishSync waits for the broker when ctx has no deadline
+var publishFlushTimeout = 10 * time.Second
+
+// DataReply is the acknowledgement received by PublishRequest
+type DataReply struct {
+	// Sender is the identity of the instance that replied
+	Sender string
+
+	// Data is the reply sent by the target agent
+	Data []byte
+}
+
+// PublishSync publishes item without using the outbox and returns once the broker received it,
+// while not connected it retries until ctx is done.  Items published using PublishSync are not
+// ordered relative to those published using Publish
+func (m *Management) PublishSync(ctx context.Context, item *DataItem) error {
+	_, err := m.publishSync(ctx, item, false)
+	return err
+}
+
+// PublishRequest publishes item as a request to its TargetAgent and waits for the first reply
+// as acknowledgement, while not connected it retries until ctx is done so use a context with a
+// timeout to limit how long to wait for the reply
+func (m *Management) PublishRequest(ctx context.Context, item *DataItem) (*DataReply, error) {
+	if item.TargetAgent == "" {
+		return nil, fmt.Errorf("a target agent is required to request an acknowledgement")
+	}
+
+	return m.publishSync(ctx, item, true)
+}
+
+func (m *Management) publishSync(ctx context.Context, item *DataItem, request bool) (*DataReply, error) {
+	if !m.cfg.publishdata {
+		return nil, ErrPublisherDisabled
+	}
+
+	for {
+		var reply *DataReply
+		var err error
+
+		if request {
+			reply, err = m.requestItem(ctx, item)
+		} else {
+			err = m.flushItem(ctx, item)
+		}
+
+		if err == nil {
+			m.metrics.published(item)
+			return reply, nil
+		}
+
+		if err != errNotConnected {
+			m.metrics.failed(item, err)
+			return nil, err
+		}
+
+		select {
+		case <-time.After(publishRetryInterval):
+		case <-ctx.Done():
+			m.metrics.failed(item, err)
+			return nil, fmt.Errorf("could not publish data: %s", err)
+		}
+	}
+}
+
+// flushItem publishes item and waits for the broker to process it
+func (m *Management) flushItem(ctx context.Context, item *DataItem) error {
+	conn, msg, err := m.newDataMessage(item)
+	if err != nil {
+		return err
+	}
+
+	err = conn.Publish(msg)
+	if err != nil {
+		return fmt.Errorf("could not publish data: %s", err)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, publishFlushTimeout)
+		defer cancel()
+	}
+
+	err = conn.Nats().FlushWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("could not confirm data was published: %s", err)
+	}
+
+	return nil
+}
+
+// requestItem publishes item as a request and waits for the first reply
+func (m *Management) requestItem(ctx context.Context, item *DataItem) (*DataReply, error) {
+	conn, msg, err := m.newDataMessage(item)
+	if err != nil {
+		return nil, err
+	}
+
+	// the broker does not let servers subscribe to the usual Choria reply subjects
+	target := nats.NewInbox()
+
+	err = msg.SetReplyTo(target)
+	if err != nil {
+		return nil, fmt.Errorf("could not set reply target: %s", err)
+	}
+
+	sub, err := conn.Nats().SubscribeSync(target)
+	if err != nil {
+		return nil, fmt.Errorf("could not subscribe to replies: %s", err)
+	}
+	defer sub.Unsubscribe()
+
+	err = conn.Publish(msg)
+	if err != nil {
+		return nil, fmt.Errorf("could not publish data: %s", err)
+	}
+
+	rmsg, err := sub.NextMsgWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("did not receive an acknowledgement: %s", err)
+	}
+
+	m.mu.Lock()
+	fw := m.cfg.fw
+	m.mu.Unlock()
+
+	reply, err := fw.NewReplyFromTransportJSON(rmsg.Data, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode acknowledgement: %s", err)
+	}
+
+	return &DataReply{
+		Sender: reply.SenderID(),
+		Data:   []byte(reply.Message()),
+	}, nil
+}
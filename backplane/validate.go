@@ -0,0 +1,217 @@
+package backplane
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var validName = regexp.MustCompile("^[a-z0-9]+$")
+
+// logLevels are the log levels supported by Choria, it logs at warn level when given any other level
+var logLevels = map[string]logrus.Level{
+	"debug": logrus.DebugLevel,
+	"info":  logrus.InfoLevel,
+	"warn":  logrus.WarnLevel,
+	"error": logrus.ErrorLevel,
+	"fatal": logrus.FatalLevel,
+}
+
+// ValidationErrors is a list of problems found while validating configuration
+type ValidationErrors []error
+
+// Error implements error
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, err := range v {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, ", ")
+}
+
+// Validate checks the configuration and reports every problem found as ValidationErrors
+func (s *StandardConfiguration) Validate() error {
+	var errs ValidationErrors
+
+	switch {
+	case s.AppName == "":
+		errs = append(errs, fmt.Errorf("name is required"))
+	case !validName.MatchString(s.AppName):
+		errs = append(errs, fmt.Errorf("name %q is invalid, names must match ^[a-z0-9]+$", s.AppName))
+	}
+
+	if len(s.Brokers) == 0 && s.SRVDomainName == "" {
+		errs = append(errs, fmt.Errorf("at least one broker or a srv_domain is required"))
+	}
+
+	for _, b := range s.Brokers {
+		err := validateHostPort(b)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("broker %q is invalid: %s", b, err))
+		}
+	}
+
+	if _, ok := logLevels[s.Loglevel]; s.Loglevel != "" && !ok {
+		errs = append(errs, fmt.Errorf("loglevel %q is invalid, valid levels are debug, info, warn, error and fatal", s.Loglevel))
+	}
+
+	for _, r := range s.Authorization.Full {
+		_, err := regexp.Compile(r)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("full access rule %q is not a valid regular expression: %s", r, err))
+		}
+	}
+
+	for _, r := range s.Authorization.RO {
+		_, err := regexp.Compile(r)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("read only access rule %q is not a valid regular expression: %s", r, err))
+		}
+	}
+
+	if s.TLSConf != nil {
+		if s.Authorization.Insecure {
+			errs = append(errs, fmt.Errorf("insecure authorization can not be combined with TLS"))
+		}
+
+		if err := s.TLSConf.Validate(); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+	}
+
+	if s.NATSConf != nil {
+		if err := s.NATSConf.Validate(); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Validate checks the NATS authentication configuration and reports every problem found as ValidationErrors
+func (n *NATSConf) Validate() error {
+	var errs ValidationErrors
+
+	if n.User == "" && n.Password != "" {
+		errs = append(errs, fmt.Errorf("nats password is set without a user"))
+	}
+
+	if n.User != "" && n.Password == "" {
+		errs = append(errs, fmt.Errorf("nats user %s is set without a password", n.User))
+	}
+
+	if n.Credentials != "" {
+		if n.User != "" {
+			errs = append(errs, fmt.Errorf("nats credentials can not be combined with a user and password"))
+		}
+
+		err := validateReadableFile(n.Credentials)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("nats credentials %s is not readable: %s", n.Credentials, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Validate checks the TLS configuration and reports every problem found as ValidationErrors
+func (t *TLSConf) Validate() error {
+	var errs ValidationErrors
+
+	switch t.Scheme {
+	case "puppet":
+		if t.SSLDir != "" {
+			_, err := ioutil.ReadDir(t.SSLDir)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("tls ssl_dir %s is not readable: %s", t.SSLDir, err))
+			}
+		}
+
+	case "file", "manual":
+		for _, f := range []struct {
+			name string
+			path string
+		}{{"ca", t.CA}, {"cert", t.Cert}, {"key", t.Key}} {
+			if f.path == "" {
+				errs = append(errs, fmt.Errorf("tls %s is required in the %s scheme", f.name, t.Scheme))
+				continue
+			}
+
+			err := validateReadableFile(f.path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("tls %s %s is not readable: %s", f.name, f.path, err))
+			}
+		}
+
+		if t.Cache != "" {
+			stat, err := os.Stat(t.Cache)
+			if err == nil && !stat.IsDir() {
+				errs = append(errs, fmt.Errorf("tls cache %s is not a directory", t.Cache))
+			}
+		}
+
+	case "":
+		errs = append(errs, fmt.Errorf("tls scheme is required"))
+
+	default:
+		errs = append(errs, fmt.Errorf("tls scheme %q is invalid, valid schemes are puppet, file and manual", t.Scheme))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateHostPort(hp string) error {
+	host, port, err := net.SplitHostPort(hp)
+	if err != nil {
+		return fmt.Errorf("expected host:port")
+	}
+
+	if host == "" {
+		return fmt.Errorf("host is required")
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("port %q is invalid", port)
+	}
+
+	return nil
+}
+
+func validateReadableFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if stat.IsDir() {
+		return fmt.Errorf("is a directory")
+	}
+
+	return nil
+}
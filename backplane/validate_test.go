@@ -0,0 +1,38 @@
+package backplane
+
+import (
+	"testing"
+)
+
+func TestValidateLogLevel(t *testing.T) {
+	cases := map[string]bool{
+		"":        true,
+		"debug":   true,
+		"info":    true,
+		"warn":    true,
+		"error":   true,
+		"fatal":   true,
+		"trace":   false,
+		"warning": false,
+		"panic":   false,
+		"verbose": false,
+	}
+
+	for level, valid := range cases {
+		conf := &StandardConfiguration{
+			AppName:       "test",
+			Brokers:       []string{"broker.example.net:4222"},
+			Loglevel:      level,
+			Authorization: Authorization{Insecure: true},
+		}
+
+		err := conf.Validate()
+		if valid && err != nil {
+			t.Fatalf("expected level %q to be valid: %s", level, err)
+		}
+
+		if !valid && err == nil {
+			t.Fatalf("expected level %q to be invalid", level)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -41,6 +42,10 @@ var (
 	cfile    string
 	insecure bool
 
+	natsUser  string
+	natsPass  string
+	natsCreds string
+
 	fw      *choria.Framework
 	err     error
 	rpc     *rpcc.RPC
@@ -50,6 +55,7 @@ var (
 	mu      = &sync.Mutex{}
 	debug   bool
 	verbose bool
+	output  string
 )
 
 // Run runs the backplane command line
@@ -63,13 +69,21 @@ func Run() {
 
 	e := app.Command("exec", "Executes a action against a set of backplane managed services").Default()
 	e.Arg("service", "The services name to manage").Required().StringVar(&service)
-	e.Arg("action", "Action to perform against the managed service").Required().EnumVar(&action, "pause", "resume", "flip", "health", "shutdown", "ping", "info", "debuglvl", "infolvl", "warnlvl", "critlvl")
+	e.Arg("action", "Action to perform against the managed service").Required().EnumVar(&action, "pause", "resume", "flip", "health", "shutdown", "ping", "info", "factschema", "facthistory", "publisher", "debuglvl", "infolvl", "warnlvl", "critlvl")
 
 	e.Flag("wf", "Match services with a certain fact").Short('F').PlaceHolder("FACTS").StringsVar(&wf)
 	e.Flag("wi", "Match services with a certain Choria identity").Short('I').PlaceHolder("IDENTITY").StringsVar(&wi)
 	e.Flag("timeout", "How long to wait for services to respond").IntVar(&timeout)
 	e.Flag("config", "Configuration file to use").StringVar(&cfile)
 	e.Flag("insecure", "Disable TLS security").BoolVar(&insecure)
+	e.Flag("nats-user", "User to authenticate to NATS as").Envar("BACKPLANE_NATS_USER").StringVar(&natsUser)
+	e.Flag("nats-password", "Password to authenticate to NATS with").Envar("BACKPLANE_NATS_PASSWORD").StringVar(&natsPass)
+	e.Flag("nats-credentials", "NATS 2.0 credentials file to authenticate with").Envar("BACKPLANE_NATS_CREDENTIALS").ExistingFileVar(&natsCreds)
+	e.Flag("batch", "Perform the action on this many services at a time").PlaceHolder("N").IntVar(&batchSize)
+	e.Flag("batch-percent", "Perform the action on this percentage of services at a time").PlaceHolder("P").IntVar(&batchPercent)
+	e.Flag("batch-sleep", "How long to wait between batches").Default("0s").DurationVar(&batchSleep)
+	e.Flag("batch-max-errors", "Abort when more than this percentage of services in a batch fail or do not respond").Default("100").PlaceHolder("P").IntVar(&batchMaxErrors)
+	e.Flag("output", "Output format, one of table, json, yaml or csv").Short('o').Default("table").EnumVar(&output, "json", "yaml", "csv", "table")
 
 	cmd := kingpin.MustParse(app.Parse(os.Args[1:]))
 
@@ -81,10 +95,17 @@ func Run() {
 	switch cmd {
 	case e.FullCommand():
 		err := execute()
-		kingpin.FatalIfError(err, "Failed to manage services")
+		if err != nil {
+			app.Errorf("Failed to manage services: %s", err)
+		}
+
+		exitCode = finalExitCode(exitCode, err)
 	default:
 		kingpin.Fatalf("%s has not been implemented", cmd)
 	}
+
+	cancel()
+	os.Exit(exitCode)
 }
 
 func execute() error {
@@ -120,7 +141,7 @@ func execute() error {
 		wf = append(wf, "backplane_loglevelsetable=true")
 		err = genericRequest(action, true)
 
-	case "ping":
+	case "ping", "factschema", "facthistory", "publisher":
 		err = genericRequest(action, true)
 	}
 
@@ -152,6 +173,19 @@ func infoRequest() error {
 			if info.LogLevelFeature {
 				fmt.Printf("             Log Level: %s\n", info.LogLevel)
 			}
+			if info.Outbox != nil {
+				fmt.Printf("           Data Outbox: %d queued, %d spilled, %d dropped (%s)\n", info.Outbox.Queued, info.Outbox.Spilled, info.Outbox.Dropped, info.Outbox.Policy)
+			}
+			if info.Publisher != nil {
+				last := "never"
+				if info.Publisher.LastPublish != nil {
+					last = info.Publisher.LastPublish.Local().Format(time.RFC3339)
+				}
+				fmt.Printf("        Data Published: %d messages, %d bytes, %d errors, last %s\n", info.Publisher.Published, info.Publisher.Bytes, info.Publisher.Errors, last)
+			}
+			for _, sub := range info.Subscriptions {
+				fmt.Printf("          Subscription: %s: %d received, %d rejected, %d dropped, %d pending\n", sub.Subject, sub.Received, sub.Rejected, sub.Dropped, sub.Pending)
+			}
 			fmt.Printf("         Pause Feature: %s\n", boolTick(info.PauseFeature))
 			fmt.Printf("         Facts Feature: %s\n", boolTick(info.FactsFeature))
 			fmt.Printf("        Health Feature: %s\n", boolTick(info.HealthFeature))
@@ -174,7 +208,7 @@ func infoRequest() error {
 		}
 	})
 
-	if !verbose {
+	if !verbose && !structuredOutput() {
 		fmt.Println("\nPass -v to show contents of individual instance facts")
 	}
 
@@ -236,24 +270,98 @@ func healthRequest() error {
 }
 
 func performAction(action string, cb func(s string, r *rpcc.RPCReply, last bool)) error {
+	err := validateBatching()
+	if err != nil {
+		return err
+	}
+
 	nodes, err := discover()
 	if err != nil {
 		return err
 	}
 
 	if len(nodes) == 0 {
+		exitCode = exitNoDiscovery
+
+		// consumers of the json, yaml and csv output still get a document describing no replies
+		if structuredOutput() {
+			err = renderResult(action, map[string]*rpcc.RPCReply{}, nil, newActionSummary(nodes, nil))
+			if err != nil {
+				return err
+			}
+		}
+
 		return fmt.Errorf("did not discover any nodes")
 	}
 
-	replies, stats, err := request(action, json.RawMessage("{}"), nodes)
-	if err != nil {
-		return fmt.Errorf("request failed: %s", err)
+	sort.Strings(nodes)
+
+	batches := batchNodes(nodes)
+
+	replies := make(map[string]*rpcc.RPCReply)
+	stats := []*rpcc.Stats{}
+	performed := []string{}
+	skipped := []string{}
+
+	for i, batch := range batches {
+		if len(batches) > 1 {
+			if i > 0 && !waitForBatch() {
+				skipped = flattenBatches(batches[i:])
+				break
+			}
+
+			fmt.Fprintf(progress(), "Batch %d of %d with %d service(s)\n\n", i+1, len(batches), len(batch))
+		}
+
+		breplies, bstats, err := request(action, json.RawMessage("{}"), batch)
+		if err != nil {
+			return fmt.Errorf("request failed: %s", err)
+		}
+
+		for svcs, reply := range breplies {
+			replies[svcs] = reply
+		}
+
+		stats = append(stats, bstats)
+		performed = append(performed, batch...)
+
+		if !structuredOutput() {
+			showReplies(breplies, bstats, cb)
+		}
+
+		if len(batches) == 1 {
+			break
+		}
+
+		bsummary := newActionSummary(batch, breplies)
+		fmt.Fprintf(progress(), "Batch %d of %d: %d ok, %d failed, %d did not respond\n\n", i+1, len(batches), bsummary.OK, len(bsummary.Failed), len(bsummary.NoResponses))
+
+		if i < len(batches)-1 && bsummary.exceedsErrorThreshold() {
+			fmt.Fprintf(progress(), "Aborting, %.0f%% of services in batch %d failed or did not respond which is more than the %d%% allowed\n", bsummary.errorRate(), i+1, batchMaxErrors)
+			skipped = flattenBatches(batches[i+1:])
+			break
+		}
+	}
+
+	summary := newActionSummary(performed, replies)
+	summary.skip(skipped)
+	exitCode = summary.ExitCode
+
+	if structuredOutput() {
+		return renderResult(action, replies, stats, summary)
 	}
 
+	summary.render(os.Stdout, replies)
+
 	if len(replies) == 0 {
 		return fmt.Errorf("no responses received")
 	}
 
+	return nil
+}
+
+// showReplies passes every reply to cb and reports how many services were managed
+func showReplies(replies map[string]*rpcc.RPCReply, stats *rpcc.Stats, cb func(s string, r *rpcc.RPCReply, last bool)) {
 	count := len(replies)
 	seen := 0
 
@@ -265,8 +373,6 @@ func performAction(action string, cb func(s string, r *rpcc.RPCReply, last bool)
 
 	d, _ := stats.RequestDuration()
 	fmt.Printf("\nManaged %d service(s) in %dms\n", stats.OKCount(), int64(d/time.Millisecond))
-
-	return nil
 }
 
 func configure() (*choria.Framework, error) {
@@ -292,11 +398,25 @@ func configure() (*choria.Framework, error) {
 		protocol.Secure = "false"
 	}
 
+	if natsUser != "" {
+		cfg.Choria.NatsUser = natsUser
+		cfg.Choria.NatsPass = natsPass
+	}
+
+	if natsCreds != "" {
+		cfg.Choria.NatsCredentials = natsCreds
+	}
+
 	fw, err := choria.NewWithConfig(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("could not initialize choria: %s", err)
 	}
 
+	// console logging would otherwise be mixed into the document on stdout
+	if structuredOutput() && cfg.LogFile == "" {
+		fw.SetLogWriter(os.Stderr)
+	}
+
 	return fw, nil
 }
 
@@ -309,17 +429,17 @@ func discover() (n []string, err error) {
 		return n, fmt.Errorf("could not parse filters: %s", err)
 	}
 
-	fmt.Printf("Starting discovery process for %s backplane managed services: ", service)
+	fmt.Fprintf(progress(), "Starting discovery process for %s backplane managed services: ", service)
 
 	b := broadcast.New(fw)
 
 	n, err = b.Discover(ctx, broadcast.Filter(filter))
 	if err != nil {
-		fmt.Println("")
+		fmt.Fprintln(progress(), "")
 		return n, fmt.Errorf("could not perform discovery: %s", err)
 	}
 
-	fmt.Printf("%d\n\n", len(n))
+	fmt.Fprintf(progress(), "%d\n\n", len(n))
 
 	return
 }
@@ -368,14 +488,14 @@ func request(action string, input json.RawMessage, nodes []string) (replies map[
 
 		replies[r.SenderID()] = rep
 
-		fmt.Print(twirl(fmt.Sprintf("Performing %s...", action), len(nodes), cnt))
+		fmt.Fprint(progress(), twirl(fmt.Sprintf("Performing %s...", action), len(nodes), cnt))
 	}))
 
 	if err != nil {
 		return
 	}
 
-	fmt.Printf("\n\n")
+	fmt.Fprintf(progress(), "\n\n")
 
 	stats = result.Stats()
 
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	batchSize      int
+	batchPercent   int
+	batchSleep     time.Duration
+	batchMaxErrors int
+)
+
+// validateBatching checks the batch related flags
+func validateBatching() error {
+	if batchSize < 0 {
+		return fmt.Errorf("the batch size can not be negative")
+	}
+
+	if batchSize > 0 && batchPercent > 0 {
+		return fmt.Errorf("only one of --batch and --batch-percent can be given")
+	}
+
+	if batchPercent < 0 || batchPercent > 100 {
+		return fmt.Errorf("the batch percentage must be between 1 and 100")
+	}
+
+	if batchMaxErrors < 0 || batchMaxErrors > 100 {
+		return fmt.Errorf("the batch error threshold must be between 0 and 100")
+	}
+
+	return nil
+}
+
+// batchNodes splits nodes into the batches the action is performed in, without
+// batching all nodes are in a single batch
+func batchNodes(nodes []string) [][]string {
+	size := batchSize
+	if batchPercent > 0 {
+		size = (len(nodes)*batchPercent + 99) / 100
+	}
+
+	if size < 1 || size >= len(nodes) {
+		return [][]string{nodes}
+	}
+
+	batches := [][]string{}
+	for start := 0; start < len(nodes); start += size {
+		end := start + size
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+
+		batches = append(batches, nodes[start:end])
+	}
+
+	return batches
+}
+
+func flattenBatches(batches [][]string) []string {
+	nodes := []string{}
+	for _, batch := range batches {
+		nodes = append(nodes, batch...)
+	}
+
+	return nodes
+}
+
+// waitForBatch sleeps between batches, returns false when interrupted
+func waitForBatch() bool {
+	if batchSleep <= 0 {
+		return true
+	}
+
+	fmt.Fprintf(progress(), "Sleeping %s before the next batch\n\n", batchSleep)
+
+	select {
+	case <-time.After(batchSleep):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// errorRate is the percentage of services in a batch that failed or did not respond
+func (s *actionSummary) errorRate() float64 {
+	if s.Discovered == 0 {
+		return 0
+	}
+
+	return float64(len(s.Failed)+len(s.NoResponses)) * 100 / float64(s.Discovered)
+}
+
+// exceedsErrorThreshold is true when more services in a batch failed than --batch-max-errors allows
+func (s *actionSummary) exceedsErrorThreshold() bool {
+	return s.errorRate() > float64(batchMaxErrors)
+}
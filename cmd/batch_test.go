@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/choria-io/go-choria/providers/agent/mcorpc"
+	rpcc "github.com/choria-io/go-choria/providers/agent/mcorpc/client"
+)
+
+func setBatching(size int, percent int, maxErrors int) {
+	batchSize = size
+	batchPercent = percent
+	batchMaxErrors = maxErrors
+}
+
+func TestBatchNodes(t *testing.T) {
+	defer setBatching(0, 0, 0)
+
+	nodes := []string{"a", "b", "c", "d", "e", "f", "g"}
+
+	cases := []struct {
+		name    string
+		size    int
+		percent int
+		batches [][]string
+	}{
+		{name: "no batching", batches: [][]string{nodes}},
+		{name: "size 1", size: 1, batches: [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}, {"f"}, {"g"}}},
+		{name: "size 3", size: 3, batches: [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {"g"}}},
+		{name: "size 5", size: 5, batches: [][]string{{"a", "b", "c", "d", "e"}, {"f", "g"}}},
+		{name: "size equal to nodes", size: 7, batches: [][]string{nodes}},
+		{name: "size larger than nodes", size: 10, batches: [][]string{nodes}},
+		{name: "30 percent rounds up", percent: 30, batches: [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {"g"}}},
+		{name: "1 percent", percent: 1, batches: [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}, {"f"}, {"g"}}},
+		{name: "100 percent", percent: 100, batches: [][]string{nodes}},
+	}
+
+	for _, c := range cases {
+		setBatching(c.size, c.percent, 0)
+
+		batches := batchNodes(nodes)
+		if !reflect.DeepEqual(batches, c.batches) {
+			t.Fatalf("%s: expected %v got %v", c.name, c.batches, batches)
+		}
+
+		if !reflect.DeepEqual(flattenBatches(batches), nodes) {
+			t.Fatalf("%s: batches do not hold every node once: %v", c.name, batches)
+		}
+	}
+}
+
+func TestValidateBatching(t *testing.T) {
+	defer setBatching(0, 0, 0)
+
+	cases := []struct {
+		size      int
+		percent   int
+		maxErrors int
+		valid     bool
+	}{
+		{valid: true},
+		{size: 2, maxErrors: 100, valid: true},
+		{percent: 100, valid: true},
+		{size: -1},
+		{size: 2, percent: 10},
+		{percent: 101},
+		{percent: -1},
+		{maxErrors: -1},
+		{maxErrors: 101},
+	}
+
+	for _, c := range cases {
+		setBatching(c.size, c.percent, c.maxErrors)
+
+		err := validateBatching()
+		if c.valid && err != nil {
+			t.Fatalf("expected %+v to be valid: %s", c, err)
+		}
+
+		if !c.valid && err == nil {
+			t.Fatalf("expected %+v to be invalid", c)
+		}
+	}
+}
+
+func TestActionSummaryErrorThreshold(t *testing.T) {
+	defer setBatching(0, 0, 0)
+
+	ok := &rpcc.RPCReply{Statuscode: mcorpc.OK}
+	failed := &rpcc.RPCReply{Statuscode: mcorpc.Aborted}
+	nodes := []string{"a", "b", "c", "d"}
+
+	cases := []struct {
+		name      string
+		replies   map[string]*rpcc.RPCReply
+		maxErrors int
+		rate      float64
+		abort     bool
+	}{
+		{name: "all ok with no errors allowed", replies: map[string]*rpcc.RPCReply{"a": ok, "b": ok, "c": ok, "d": ok}, rate: 0},
+		{name: "one failed with no errors allowed", replies: map[string]*rpcc.RPCReply{"a": ok, "b": ok, "c": ok, "d": failed}, rate: 25, abort: true},
+		{name: "one unresponsive with no errors allowed", replies: map[string]*rpcc.RPCReply{"a": ok, "b": ok, "c": ok}, rate: 25, abort: true},
+		{name: "rate equal to threshold", replies: map[string]*rpcc.RPCReply{"a": ok, "b": ok, "c": failed}, maxErrors: 50, rate: 50},
+		{name: "rate above threshold", replies: map[string]*rpcc.RPCReply{"a": ok, "b": failed}, maxErrors: 50, rate: 75, abort: true},
+		{name: "all failed with every error allowed", replies: map[string]*rpcc.RPCReply{}, maxErrors: 100, rate: 100},
+	}
+
+	for _, c := range cases {
+		setBatching(0, 0, c.maxErrors)
+
+		s := newActionSummary(nodes, c.replies)
+		if s.errorRate() != c.rate {
+			t.Fatalf("%s: expected error rate %.0f got %.0f", c.name, c.rate, s.errorRate())
+		}
+
+		if s.exceedsErrorThreshold() != c.abort {
+			t.Fatalf("%s: expected abort %v", c.name, c.abort)
+		}
+	}
+
+	s := newActionSummary([]string{}, nil)
+	if s.errorRate() != 0 {
+		t.Fatalf("expected an empty batch to have no errors")
+	}
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	rpcc "github.com/choria-io/go-choria/providers/agent/mcorpc/client"
+	"gopkg.in/yaml.v2"
+)
+
+// actionResult is the document produced by the json, yaml and csv output formats
+type actionResult struct {
+	Service string         `json:"service"`
+	Action  string         `json:"action"`
+	Replies []replyResult  `json:"replies"`
+	Stats   statsResult    `json:"stats"`
+	Batches []statsResult  `json:"batches,omitempty"`
+	Summary *actionSummary `json:"summary"`
+}
+
+type replyResult struct {
+	Sender     string      `json:"sender"`
+	Statuscode int         `json:"statuscode"`
+	Statusmsg  string      `json:"statusmsg"`
+	Data       interface{} `json:"data"`
+}
+
+type statsResult struct {
+	RequestID           string   `json:"request_id,omitempty"`
+	Discovered          int      `json:"discovered"`
+	Responses           int      `json:"responses"`
+	OK                  int      `json:"ok"`
+	Failed              int      `json:"failed"`
+	NoResponses         []string `json:"no_responses"`
+	UnexpectedResponses []string `json:"unexpected_responses"`
+	RequestTime         float64  `json:"request_time"`
+}
+
+// structuredOutput is true when results are rendered as a document rather than for humans
+func structuredOutput() bool {
+	return output != "table"
+}
+
+// progress is where discovery and progress messages are written, when producing a document
+// these go to stderr so that stdout only holds the document
+func progress() io.Writer {
+	if structuredOutput() {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+func newActionResult(action string, replies map[string]*rpcc.RPCReply, stats []*rpcc.Stats, summary *actionSummary) *actionResult {
+	result := &actionResult{
+		Service: service,
+		Action:  action,
+		Replies: []replyResult{},
+		Summary: summary,
+	}
+
+	for sender, reply := range replies {
+		r := replyResult{
+			Sender:     sender,
+			Statuscode: int(reply.Statuscode),
+			Statusmsg:  reply.Statusmsg,
+		}
+
+		if len(reply.Data) > 0 {
+			err := json.Unmarshal(reply.Data, &r.Data)
+			if err != nil {
+				r.Data = string(reply.Data)
+			}
+		}
+
+		result.Replies = append(result.Replies, r)
+	}
+
+	sort.Slice(result.Replies, func(i, j int) bool { return result.Replies[i].Sender < result.Replies[j].Sender })
+
+	result.Stats = statsResult{
+		NoResponses:         []string{},
+		UnexpectedResponses: []string{},
+	}
+
+	// when performed in batches every request has its own statistics with totals in Stats
+	for _, s := range stats {
+		batch := newStatsResult(s)
+
+		result.Stats.Discovered += batch.Discovered
+		result.Stats.Responses += batch.Responses
+		result.Stats.OK += batch.OK
+		result.Stats.Failed += batch.Failed
+		result.Stats.NoResponses = append(result.Stats.NoResponses, batch.NoResponses...)
+		result.Stats.UnexpectedResponses = append(result.Stats.UnexpectedResponses, batch.UnexpectedResponses...)
+		result.Stats.RequestTime += batch.RequestTime
+
+		if len(stats) > 1 {
+			result.Batches = append(result.Batches, batch)
+		}
+	}
+
+	if len(stats) == 1 {
+		result.Stats.RequestID = stats[0].UniqueRequestID()
+	}
+
+	return result
+}
+
+func newStatsResult(stats *rpcc.Stats) statsResult {
+	d, _ := stats.RequestDuration()
+
+	return statsResult{
+		RequestID:           stats.UniqueRequestID(),
+		Discovered:          stats.DiscoveredCount(),
+		Responses:           stats.ResponsesCount(),
+		OK:                  stats.OKCount(),
+		Failed:              stats.FailCount(),
+		NoResponses:         append([]string{}, stats.NoResponseFrom()...),
+		UnexpectedResponses: append([]string{}, stats.UnexpectedResponseFrom()...),
+		RequestTime:         d.Seconds(),
+	}
+}
+
+// renderResult writes the replies, statistics and summary to stdout in the selected output format
+func renderResult(action string, replies map[string]*rpcc.RPCReply, stats []*rpcc.Stats, summary *actionSummary) error {
+	result := newActionResult(action, replies, stats, summary)
+
+	switch output {
+	case "json":
+		j, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("could not encode result: %s", err)
+		}
+
+		fmt.Println(string(j))
+
+	case "yaml":
+		y, err := yaml.Marshal(toYAML(result))
+		if err != nil {
+			return fmt.Errorf("could not encode result: %s", err)
+		}
+
+		fmt.Print(string(y))
+
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"sender", "statuscode", "statusmsg", "data"})
+
+		for _, r := range result.Replies {
+			data := ""
+			if r.Data != nil {
+				j, err := json.Marshal(r.Data)
+				if err == nil {
+					data = string(j)
+				}
+			}
+
+			w.Write([]string{r.Sender, strconv.Itoa(r.Statuscode), r.Statusmsg, data})
+		}
+
+		w.Flush()
+
+		return w.Error()
+	}
+
+	return nil
+}
+
+// toYAML converts result to generic maps via JSON so that reply data is
+// rendered using the same keys as in the json output
+func toYAML(result *actionResult) interface{} {
+	j, err := json.Marshal(result)
+	if err != nil {
+		return result
+	}
+
+	var doc yaml.MapSlice
+	err = yaml.Unmarshal(j, &doc)
+	if err != nil {
+		return result
+	}
+
+	return doc
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/choria-io/go-choria/providers/agent/mcorpc"
+	rpcc "github.com/choria-io/go-choria/providers/agent/mcorpc/client"
+	"github.com/fatih/color"
+)
+
+// exit codes used by the CLI, when some services failed and others did not
+// respond exitUnresponsive is used
+const (
+	exitOK           = 0
+	exitError        = 1
+	exitFailed       = 2
+	exitUnresponsive = 3
+	exitNoDiscovery  = 4
+	exitAborted      = 5
+)
+
+// exitCode is the code the CLI exits with once the action completed
+var exitCode = exitOK
+
+// finalExitCode is the code to exit with after performing an action, errors that did not
+// already set a more specific code exit with exitError
+func finalExitCode(code int, err error) int {
+	if err != nil && code == exitOK {
+		return exitError
+	}
+
+	return code
+}
+
+// actionSummary compares the discovered services with the replies received
+type actionSummary struct {
+	Discovered  int      `json:"discovered"`
+	OK          int      `json:"ok"`
+	Failed      []string `json:"failed"`
+	NoResponses []string `json:"no_responses"`
+	Skipped     []string `json:"skipped"`
+	ExitCode    int      `json:"exit_code"`
+}
+
+func newActionSummary(nodes []string, replies map[string]*rpcc.RPCReply) *actionSummary {
+	s := &actionSummary{
+		Discovered:  len(nodes),
+		Failed:      []string{},
+		NoResponses: []string{},
+		Skipped:     []string{},
+	}
+
+	for _, node := range nodes {
+		reply, ok := replies[node]
+		switch {
+		case !ok:
+			s.NoResponses = append(s.NoResponses, node)
+		case reply.Statuscode == mcorpc.OK:
+			s.OK++
+		default:
+			s.Failed = append(s.Failed, node)
+		}
+	}
+
+	sort.Strings(s.Failed)
+	sort.Strings(s.NoResponses)
+
+	switch {
+	case s.Discovered == 0:
+		s.ExitCode = exitNoDiscovery
+	case len(s.NoResponses) > 0:
+		s.ExitCode = exitUnresponsive
+	case len(s.Failed) > 0:
+		s.ExitCode = exitFailed
+	default:
+		s.ExitCode = exitOK
+	}
+
+	return s
+}
+
+// skip records services that were not asked to perform the action because the batches were aborted
+func (s *actionSummary) skip(nodes []string) {
+	if len(nodes) == 0 {
+		return
+	}
+
+	s.Discovered += len(nodes)
+	s.Skipped = append(s.Skipped, nodes...)
+	s.ExitCode = exitAborted
+
+	sort.Strings(s.Skipped)
+}
+
+// render writes a table listing the services that failed or did not respond
+func (s *actionSummary) render(w io.Writer, replies map[string]*rpcc.RPCReply) {
+	if len(s.Failed) == 0 && len(s.NoResponses) == 0 && len(s.Skipped) == 0 {
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "\nSummary: %d discovered, %d ok, %d failed, %d did not respond", s.Discovered, s.OK, len(s.Failed), len(s.NoResponses))
+	if len(s.Skipped) > 0 {
+		fmt.Fprintf(tw, ", %d skipped", len(s.Skipped))
+	}
+	fmt.Fprintf(tw, "\n\n")
+
+	for _, node := range s.Failed {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", node, color.RedString("failed"), replies[node].Statusmsg)
+	}
+
+	for _, node := range s.NoResponses {
+		fmt.Fprintf(tw, "  %s\t%s\n", node, color.RedString("no response"))
+	}
+
+	for _, node := range s.Skipped {
+		fmt.Fprintf(tw, "  %s\t%s\n", node, color.YellowString("skipped"))
+	}
+
+	tw.Flush()
+}
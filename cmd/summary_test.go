@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/choria-io/go-choria/providers/agent/mcorpc"
+	rpcc "github.com/choria-io/go-choria/providers/agent/mcorpc/client"
+)
+
+func TestActionSummaryExitCodes(t *testing.T) {
+	ok := &rpcc.RPCReply{Statuscode: mcorpc.OK}
+	failed := &rpcc.RPCReply{Statuscode: mcorpc.Aborted, Statusmsg: "failed"}
+
+	cases := []struct {
+		name    string
+		nodes   []string
+		replies map[string]*rpcc.RPCReply
+		skipped []string
+		err     error
+		code    int
+	}{
+		{name: "all ok", nodes: []string{"a", "b"}, replies: map[string]*rpcc.RPCReply{"a": ok, "b": ok}, code: exitOK},
+		{name: "error", nodes: []string{"a"}, replies: map[string]*rpcc.RPCReply{"a": ok}, err: errors.New("request failed"), code: exitError},
+		{name: "failed", nodes: []string{"a", "b"}, replies: map[string]*rpcc.RPCReply{"a": ok, "b": failed}, code: exitFailed},
+		{name: "unresponsive", nodes: []string{"a", "b"}, replies: map[string]*rpcc.RPCReply{"a": ok}, code: exitUnresponsive},
+		{name: "unresponsive and failed", nodes: []string{"a", "b", "c"}, replies: map[string]*rpcc.RPCReply{"a": failed}, code: exitUnresponsive},
+		{name: "no discovery", nodes: []string{}, replies: map[string]*rpcc.RPCReply{}, code: exitNoDiscovery},
+		{name: "no discovery with error", nodes: []string{}, replies: map[string]*rpcc.RPCReply{}, err: errors.New("did not discover any nodes"), code: exitNoDiscovery},
+		{name: "aborted", nodes: []string{"a"}, replies: map[string]*rpcc.RPCReply{"a": failed}, skipped: []string{"b", "c"}, code: exitAborted},
+	}
+
+	for _, c := range cases {
+		s := newActionSummary(c.nodes, c.replies)
+		s.skip(c.skipped)
+
+		code := finalExitCode(s.ExitCode, c.err)
+		if code != c.code {
+			t.Fatalf("%s: expected exit code %d got %d", c.name, c.code, code)
+		}
+	}
+}
+
+func TestActionSummaryCounts(t *testing.T) {
+	replies := map[string]*rpcc.RPCReply{
+		"a": {Statuscode: mcorpc.OK},
+		"c": {Statuscode: mcorpc.Aborted},
+		"b": {Statuscode: mcorpc.UnknownAction},
+	}
+
+	s := newActionSummary([]string{"a", "b", "c", "e", "d"}, replies)
+	s.skip([]string{"g", "f"})
+
+	if s.Discovered != 7 || s.OK != 1 {
+		t.Fatalf("expected 7 discovered and 1 ok got %d and %d", s.Discovered, s.OK)
+	}
+
+	if !reflect.DeepEqual(s.Failed, []string{"b", "c"}) {
+		t.Fatalf("unexpected failed services %v", s.Failed)
+	}
+
+	if !reflect.DeepEqual(s.NoResponses, []string{"d", "e"}) {
+		t.Fatalf("unexpected unresponsive services %v", s.NoResponses)
+	}
+
+	if !reflect.DeepEqual(s.Skipped, []string{"f", "g"}) {
+		t.Fatalf("unexpected skipped services %v", s.Skipped)
+	}
+}
@@ -10,18 +10,13 @@ EOF
 cat <<EOF > /myapp.yaml
 interval: 2
 name: ${NAME}
+EOF
 
 # Standard Backplane specific configuration here
-management:
-    name: ${NAME}
-    loglevel: info
-
-    auth:
-        insecure: true
-
-    brokers:
-        - ${BROKER}
-EOF
+export BACKPLANE_NAME=${NAME}
+export BACKPLANE_LOGLEVEL=info
+export BACKPLANE_AUTH_INSECURE=true
+export BACKPLANE_BROKERS=${BROKER}
 
 if [ "${EXAMPLE}0" -eq "10" ];
 then
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"io/ioutil"
 	"log"
 	"os"
@@ -52,7 +53,11 @@ func (a *App) work(ctx context.Context, wg *sync.WaitGroup) {
 				continue
 			}
 
-			a.bp.DataOutbox() <- &backplane.DataItem{Data: dat, Destination: "myapp.data"}
+			err = a.bp.Publish(&backplane.DataItem{Data: dat, Destination: "myapp.data"})
+			if err != nil {
+				log.Printf("Could not publish data: %s", err)
+				continue
+			}
 
 			log.Println(a.config.Name + ": doing work - published " + string(dat))
 		case <-ctx.Done():
@@ -71,6 +76,15 @@ func (a *App) data() ([]byte, error) {
 }
 
 func main() {
+	loader := backplane.NewLoader().
+		WithSection("management").
+		AddFile("myapp.yaml").
+		WithEnvironment("").
+		BindFlags(flag.CommandLine, "management.")
+
+	printConfig := flag.Bool("print-config", false, "Prints the effective backplane configuration and exit")
+	flag.Parse()
+
 	if _, err := os.Stat("myapp.yaml"); err != nil {
 		log.Fatal("Cannot find myapp.yaml")
 	}
@@ -90,8 +104,14 @@ func main() {
 		config.Interval = 10
 	}
 
-	if config.Management == nil {
-		log.Fatal("Management configuration is not provided")
+	config.Management, err = loader.Load()
+	if err != nil {
+		log.Fatalf("Could not load the management configuration: %s", err)
+	}
+
+	if *printConfig {
+		loader.PrintEffective(os.Stdout)
+		return
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -3,9 +3,12 @@ module github.com/choria-io/go-backplane
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/choria-io/go-choria v0.23.1-0.20210827140645-aa647a04a97d
 	github.com/fatih/color v1.12.0
 	github.com/hokaccha/go-prettyjson v0.0.0-20210113012101-fb4e108d2519
+	github.com/klauspost/compress v1.13.4
+	github.com/nats-io/nats.go v1.12.0
 	github.com/sirupsen/logrus v1.8.1
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/yaml.v2 v2.4.0